@@ -0,0 +1,114 @@
+package cookies
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Command implements 'cookies' command
+type Command struct {
+	host lib.Host
+
+	loader loader.Loader
+	url    string
+	format string
+}
+
+// GetDescription implements Command.GetDescription
+func (c *Command) GetDescription() string {
+	return "Load a specific page and export its cookies and storage"
+}
+
+// ShowHelp implements Command.ShowHelp
+func (c *Command) ShowHelp() {
+	os.Stderr.WriteString(`Description:
+
+	Load a specific page, wait for a specific page lifecycle event,
+	and output all browser cookies, as well as localStorage
+	and sessionStorage items for the origins of all page frames.
+
+	The output can be passed back to page-loading commands
+	via --storage-file flag (cookies and storage items) or
+	--cookie-file flag (cookies only). Netscape cookies.txt format
+	(--format netscape) contains cookies only
+
+Usage:
+
+	hc cookies [options] <URL>
+	hc cookies --help
+
+Available options:
+
+`)
+
+	flag.PrintDefaults()
+}
+
+// Init implements Command.Init
+func (c *Command) Init(host lib.Host) {
+	c.host = host
+
+	flag.StringVar(&c.format, "format", "json", "Output format ('json' or 'netscape')")
+
+	c.loader.Init(host, "Extra time to wait before capturing data")
+}
+
+// Validate implements Command.Validate
+func (c *Command) Validate(args []string) {
+	c.loader.Validate()
+
+	if len(args) != 1 {
+		util.StopOnUsageError(
+			"Usage: hc cookies [options] <URL>\n" +
+				"       hc cookies --help\n",
+		)
+	}
+
+	c.url = args[0]
+
+	switch c.format {
+	case "json", "netscape":
+		break
+	default:
+		util.StopOnUsageError(fmt.Sprintf(
+			"Unknown format: '%s'. Available formats: 'json' or 'netscape'\n",
+			c.format,
+		))
+	}
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
+}
+
+// Run implements Command.Run
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
+	if err != nil {
+		return
+	}
+	defer c.host.DisconnectFromRemote()
+
+	_, err = c.loader.Load(ctx, remote, c.url)
+	if err != nil {
+		return
+	}
+
+	dump, err := util.DumpStorage(remote)
+	if err != nil {
+		return
+	}
+
+	if c.format == "netscape" {
+		return util.WriteNetscapeCookies(outfile, dump.Cookies)
+	}
+
+	return dump.WriteJSON(outfile)
+}
@@ -1,26 +1,21 @@
 package debug
 
 import (
+	"context"
 	"flag"
-	"fmt"
 	"os"
-	"strings"
-	"time"
-
-	"github.com/raff/godet"
 
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
 )
 
 // Command implements 'load' command
 type Command struct {
 	host lib.Host
 
-	blockedURLsParam string
-	blockedURLs      []string
-	url              string
-	stopEvent        string
-	wait             time.Duration
+	loader loader.Loader
+	url    string
 }
 
 // GetDescription implements Command.GetDescription
@@ -53,74 +48,44 @@ Available options:
 func (c *Command) Init(host lib.Host) {
 	c.host = host
 
-	flag.StringVar(&c.stopEvent, "stop-event", "networkIdle", "Event to stop upon")
-	flag.DurationVar(&c.wait, "wait", 500*time.Millisecond, "Extra time to wait before running the script")
-
-	flag.StringVar(
-		&c.blockedURLsParam,
-		"blocked-urls",
-		"",
-		"Comma-separated list of file masks to block from loading",
-	)
+	c.loader.Init(host, "Extra time to wait before running the script")
 }
 
 // Validate implements Command.Validate
 func (c *Command) Validate(args []string) {
-	if c.blockedURLsParam != "" {
-		c.blockedURLs = strings.Split(c.blockedURLsParam, ",")
-	}
+	c.loader.Validate()
 
 	if len(args) != 1 {
-		os.Stderr.WriteString("Usage: hc debug [options] <URL>\n")
-		os.Stderr.WriteString("       hc debug --help\n")
-		os.Exit(2)
+		util.StopOnUsageError(
+			"Usage: hc debug [options] <URL>\n" +
+				"       hc debug --help\n",
+		)
 	}
 
 	c.url = args[0]
 }
 
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
+}
+
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
-	remote, err := c.host.ConnectToRemote()
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
 	if err != nil {
 		return
 	}
 	defer c.host.DisconnectFromRemote()
 
-	// block resource loading
-	if len(c.blockedURLs) > 0 {
-		err = remote.SetBlockedURLs(c.blockedURLs...)
-		if err != nil {
-			return
-		}
-	}
-
-	remote.AllEvents(true)
+	err = remote.AllEvents(true)
 	if err != nil {
 		return
 	}
 
-	tabID, err := remote.Navigate(c.url)
-
-	status := make(chan bool, 2)
-	result := false
-
-	go func() {
-		time.Sleep(c.host.GetDeadline())
-		status <- false
-	}()
-
-	remote.CallbackEvent("Page.lifecycleEvent", func(params godet.Params) {
-		if params["name"] == c.stopEvent && params["frameId"] == tabID {
-			time.Sleep(c.wait)
-			status <- true
-		}
-	})
-
-	result = <-status
-
-	if !result {
-		return fmt.Errorf("Request timed out")
+	_, err = c.loader.Load(ctx, remote, c.url)
+	if err != nil {
+		return
 	}
 
 	return
@@ -1,27 +1,34 @@
 package eval
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"log"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/raff/godet"
 
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
 )
 
 // Command implements 'load' command
 type Command struct {
 	host lib.Host
 
-	blockedURLsParam string
-	blockedURLs      []string
-	url              string
-	stopEvent        string
-	evalStr          string
-	wait             time.Duration
+	loader          loader.Loader
+	url             string
+	evalStr         string
+	scriptFiles     util.StringList
+	scriptArgs      util.StringList
+	dumpStorageFile string
+	outputFormat    string
+	frame           string
+	isolatedWorld   string
 }
 
 // GetDescription implements Command.GetDescription
@@ -39,8 +46,20 @@ func (c *Command) ShowHelp() {
 Usage:
 
 	hc eval [options] <URL> <JavaScript-expression>
+	hc eval [options] --script-file <file> [--script-file <file>...] <URL> [<JavaScript-expression>]
+	hc eval [options] --urls-file <file> --output-file <template> <JavaScript-expression>
 	hc eval --help
 
+	Script files ('-' for STDIN) are executed in the order they are given,
+	followed by the expression, in the scope of a single function, so that
+	functions defined in one file can be used by the following ones;
+	use 'return' to return the result. Named arguments passed with '--arg'
+	are available in the 'args' object.
+
+	The code can use 'await', and returned promises are awaited until
+	the deadline. Thrown exceptions and rejected promises are reported
+	with the JavaScript stack trace and exit code 11.
+
 Available options:
 
 `)
@@ -52,84 +71,199 @@ Available options:
 func (c *Command) Init(host lib.Host) {
 	c.host = host
 
-	flag.StringVar(&c.stopEvent, "stop-event", "networkIdle", "Event to stop upon")
-	flag.DurationVar(&c.wait, "wait", 500*time.Millisecond, "Extra time to wait before running the script")
+	flag.StringVar(
+		&c.dumpStorageFile,
+		"dump-storage",
+		"",
+		"File to write cookies, localStorage and sessionStorage to (in JSON format) after running the script; "+
+			"supports the same macros as --output-file",
+	)
 
+	flag.Var(&c.scriptFiles, "script-file", "File with JavaScript code to execute before the expression ('-' for STDIN; can be repeated)")
+	flag.Var(&c.scriptArgs, "arg", "Named argument in 'key=value' format available to the script as 'args.key' (can be repeated)")
 	flag.StringVar(
-		&c.blockedURLsParam,
-		"blocked-urls",
+		&c.frame,
+		"frame",
 		"",
-		"Comma-separated list of file masks to block from loading",
+		"Frame to evaluate the expression in: its name, a URL substring or a CSS selector of the frame element "+
+			"(including cross-origin frames); the main frame by default",
 	)
+	flag.StringVar(
+		&c.isolatedWorld,
+		"isolated-world",
+		"",
+		"Name of an isolated world to evaluate the expression in, hidden from page scripts (e.g. the one of --preload-world)",
+	)
+	flag.StringVar(
+		&c.outputFormat,
+		"output-format",
+		formatRaw,
+		"Format of the result: 'raw' (strings as is followed by a newline, other values as JSON), 'json', "+
+			"'jsonl' (array elements one per line) or 'csv' (array of objects or arrays)",
+	)
+
+	c.loader.Init(host, "Extra time to wait before running the script")
 }
 
 // Validate implements Command.Validate
 func (c *Command) Validate(args []string) {
-	if c.blockedURLsParam != "" {
-		c.blockedURLs = strings.Split(c.blockedURLsParam, ",")
+	c.loader.Validate()
+
+	err := validateFormat(c.outputFormat)
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+
+	if c.dumpStorageFile != "" {
+		err = c.host.ValidateFileName(c.dumpStorageFile)
+		if err != nil {
+			util.StopOnUsageError(fmt.Sprintf("Invalid --dump-storage file name: %v", err))
+		}
+	}
+
+	// in batch mode, URLs are read from the file
+	nargs := 2
+	if c.host.IsBatch() {
+		nargs = 1
+	}
+
+	// the expression is optional if scripts are read from files
+	minArgs := nargs
+	if len(c.scriptFiles) > 0 {
+		minArgs = nargs - 1
+	}
+
+	if len(args) < minArgs || len(args) > nargs {
+		util.StopOnUsageError(
+			"Usage: hc eval [options] <URL> <JavaScript-expression>\n" +
+				"       hc eval [options] --script-file <file> <URL> [<JavaScript-expression>]\n" +
+				"       hc eval [options] --urls-file <file> --output-file <template> <JavaScript-expression>\n" +
+				"       hc eval --help\n",
+		)
+	}
+
+	if nargs == 2 {
+		c.url = args[0]
+	}
+
+	expr := ""
+	if len(args) == nargs {
+		expr = args[nargs-1]
+	}
+
+	c.evalStr, err = c.buildScript(expr)
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+}
+
+// buildScript joins script files and the expression into
+// a single async function called with the 'args' object,
+// so that the code can use 'await'
+func (c *Command) buildScript(expr string) (script string, err error) {
+	args := make(map[string]string)
+	for _, p := range c.scriptArgs {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("Invalid --arg value: '%s'. Expected format: 'key=value'", p)
+		}
+		args[parts[0]] = parts[1]
+	}
+
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return
+	}
+
+	var parts []string
+	for _, filename := range c.scriptFiles {
+		var data []byte
+		data, err = util.ReadInputFile(filename, "--script-file")
+		if err != nil {
+			return "", fmt.Errorf("Failed to read script file [%s]: %v", filename, err)
+		}
+		parts = append(parts, string(data))
 	}
 
-	if len(args) != 2 {
-		os.Stderr.WriteString("Usage: hc eval [options] <URL> <JavaScript-expression>\n")
-		os.Stderr.WriteString("       hc eval --help\n")
-		os.Exit(2)
+	if expr != "" {
+		parts = append(parts, expr)
 	}
 
-	c.url = args[0]
-	c.evalStr = args[1]
+	// separate parts with semicolons in case
+	// a file doesn't end with one
+	return fmt.Sprintf("(async function(args) {\n%s\n})(%s)", strings.Join(parts, "\n;\n"), argsJSON), nil
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
 }
 
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
-	remote, err := c.host.ConnectToRemote()
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
 	if err != nil {
 		return
 	}
 	defer c.host.DisconnectFromRemote()
 
-	// block resource loading
-	if len(c.blockedURLs) > 0 {
-		err = remote.SetBlockedURLs(c.blockedURLs...)
+	return c.RunURL(ctx, c.host, remote, c.url, outfile)
+}
+
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
+	_, err = c.loader.Clone(host).Load(ctx, remote, url)
+	if err != nil {
+		return
+	}
+
+	// evaluate Javascript expression in existing context
+	ec := &util.ExecutionContext{Session: remote}
+	if c.frame != "" || c.isolatedWorld != "" {
+		ec, err = util.FindExecutionContext(ctx, remote, c.frame, c.isolatedWorld)
 		if err != nil {
 			return
 		}
+		defer ec.Release()
 	}
 
-	remote.PageEvents(true)
+	res, err := util.Evaluate(ctx, ec, c.evalStr)
 	if err != nil {
 		return
 	}
 
-	tabID, err := remote.Navigate(c.url)
-
-	status := make(chan bool, 2)
-	result := false
+	err = writeResult(outfile, res, c.outputFormat)
+	if err != nil {
+		return
+	}
 
-	go func() {
-		time.Sleep(c.host.GetDeadline())
-		status <- false
-	}()
+	if c.dumpStorageFile != "" {
+		err = c.dumpStorage(host, remote)
+	}
 
-	remote.CallbackEvent("Page.lifecycleEvent", func(params godet.Params) {
-		if params["name"] == c.stopEvent && params["frameId"] == tabID {
-			time.Sleep(c.wait)
-			status <- true
-		}
-	})
+	return
+}
 
-	result = <-status
+func (c *Command) dumpStorage(host lib.Host, remote *godet.RemoteDebugger) (err error) {
+	dump, err := util.DumpStorage(remote)
+	if err != nil {
+		return
+	}
 
-	if !result {
-		return fmt.Errorf("Request timed out")
+	filename := host.ExpandFileName(c.dumpStorageFile)
+	if host.GetVerbose() {
+		log.Printf("Writing cookies and storage to [%s]", filename)
 	}
 
-	// evaluate Javascript expression in existing context
-	res, err := remote.EvaluateWrap(c.evalStr)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return err
+		return
 	}
 
-	outfile.WriteString(fmt.Sprintf("%v", res))
-
+	err = dump.WriteJSON(file)
+	err2 := file.Close()
+	if err == nil {
+		err = err2
+	}
 	return
 }
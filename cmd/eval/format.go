@@ -0,0 +1,161 @@
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Output formats of the evaluated value
+const (
+	formatRaw   = "raw"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case formatRaw, formatJSON, formatJSONL, formatCSV:
+		return nil
+	}
+	return fmt.Errorf(
+		"Unknown output format: '%s'. Available formats: 'raw', 'json', 'jsonl' or 'csv'",
+		format,
+	)
+}
+
+// writeResult writes the evaluated value in the given format:
+// raw writes strings as is (followed by a newline) and other values
+// as JSON, json writes an indented JSON document, jsonl writes each
+// element of an array as JSON on a separate line, and csv writes
+// an array of objects (or arrays) as rows
+func writeResult(w io.Writer, value interface{}, format string) error {
+	switch format {
+	case formatJSON:
+		return writeJSON(w, value, true)
+
+	case formatJSONL:
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			err := writeJSON(w, item, false)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case formatCSV:
+		return writeCSV(w, value)
+
+	default:
+		if s, ok := value.(string); ok {
+			_, err := io.WriteString(w, s+"\n")
+			return err
+		}
+		return writeJSON(w, value, false)
+	}
+}
+
+// writeJSON writes the value followed by a newline; unlike json.Marshal,
+// it doesn't escape '<', '>' and '&' often found in page content
+func writeJSON(w io.Writer, value interface{}, indent bool) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(value)
+}
+
+// writeCSV writes an array as CSV rows: objects are flattened into
+// columns named after their (dot-separated, for nested objects) keys
+// in alphabetical order, with a header row; arrays are written as is,
+// and other values as single-column rows
+func writeCSV(w io.Writer, value interface{}) error {
+	rows, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("CSV output format requires the expression to return an array")
+	}
+
+	objects := make([]map[string]interface{}, len(rows))
+	seen := make(map[string]bool)
+	var columns []string
+
+	for i, row := range rows {
+		obj, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		objects[i] = make(map[string]interface{})
+		flatten("", obj, objects[i])
+		for key := range objects[i] {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	cw := csv.NewWriter(w)
+	if len(columns) > 0 {
+		cw.Write(columns)
+	}
+
+	for i, row := range rows {
+		var record []string
+		switch row := row.(type) {
+		case map[string]interface{}:
+			for _, col := range columns {
+				record = append(record, csvField(objects[i][col]))
+			}
+		case []interface{}:
+			for _, v := range row {
+				record = append(record, csvField(v))
+			}
+		default:
+			record = []string{csvField(row)}
+		}
+		cw.Write(record)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// flatten copies values of nested objects into dst
+// under dot-separated keys
+func flatten(prefix string, obj map[string]interface{}, dst map[string]interface{}) {
+	for key, v := range obj {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(key, nested, dst)
+			continue
+		}
+		dst[key] = v
+	}
+}
+
+func csvField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,168 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Command implements 'extract' command
+type Command struct {
+	host lib.Host
+
+	loader loader.Loader
+	url    string
+	script string
+}
+
+// GetDescription implements Command.GetDescription
+func (c *Command) GetDescription() string {
+	return "Load a page and extract data described by a schema"
+}
+
+// ShowHelp implements Command.ShowHelp
+func (c *Command) ShowHelp() {
+	os.Stderr.WriteString(`Description:
+
+	Load a specific page and extract data described by a schema
+	(a YAML or JSON file; '-' for STDIN) mapping field names to CSS
+	selectors or XPath expressions, and print it as JSON:
+
+		title: h1
+		links:
+		  selector: a
+		  attr: href
+		  list: true
+		price:
+		  xpath: //span[@class='price']
+		  regex: '([0-9.,]+)'
+		  type: number
+		products:
+		  selector: .product
+		  list: true
+		  fields:
+		    name: .name
+		    url:
+		      selector: a
+		      attr: href
+
+	Field definition keys:
+
+		selector  CSS selector, relative to the parent field's element
+		xpath     XPath expression (use './/' to match relative to the parent)
+		attr      attribute to extract
+		extract   'text' (default; whitespace is collapsed), 'html' or 'outer-html'
+		list      extract all matches as a list (only the first one by default)
+		regex     JavaScript regular expression applied to the value;
+		          the first group (or the whole match) is used
+		type      'string' (default), 'number', 'integer' or 'boolean'
+		fields    nested fields extracted from the matched element
+
+	Missing elements and values not matching the regex are extracted as null.
+	Regular expressions are compiled by the browser after the page is
+	loaded; invalid ones are reported with exit code 2.
+
+Usage:
+
+	hc extract [options] <URL> <schema-file>
+	hc extract [options] --urls-file <file> --output-file <template> <schema-file>
+	hc extract --help
+
+Available options:
+
+`)
+
+	flag.PrintDefaults()
+}
+
+// Init implements Command.Init
+func (c *Command) Init(host lib.Host) {
+	c.host = host
+
+	c.loader.Init(host, "Extra time to wait before extracting data")
+}
+
+// Validate implements Command.Validate
+func (c *Command) Validate(args []string) {
+	c.loader.Validate()
+
+	// in batch mode, URLs are read from the file
+	nargs := 2
+	if c.host.IsBatch() {
+		nargs = 1
+	}
+
+	if len(args) != nargs {
+		util.StopOnUsageError(
+			"Usage: hc extract [options] <URL> <schema-file>\n" +
+				"       hc extract [options] --urls-file <file> --output-file <template> <schema-file>\n" +
+				"       hc extract --help\n",
+		)
+	}
+
+	if nargs == 2 {
+		c.url = args[0]
+	}
+
+	schema, err := ReadSchema(args[nargs-1])
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+
+	c.script, err = schema.Script()
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
+}
+
+// Run implements Command.Run
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
+	if err != nil {
+		return
+	}
+	defer c.host.DisconnectFromRemote()
+
+	return c.RunURL(ctx, c.host, remote, c.url, outfile)
+}
+
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
+	_, err = c.loader.Clone(host).Load(ctx, remote, url)
+	if err != nil {
+		return
+	}
+
+	res, err := util.Evaluate(ctx, &util.ExecutionContext{Session: remote}, c.script)
+	if err != nil {
+		return
+	}
+
+	result, _ := res.(map[string]interface{})
+	if message, ok := result["error"].(string); ok {
+		return util.WithExitCode(util.ExitUsage, errors.New(message))
+	}
+
+	return writeJSON(outfile, result["data"])
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
@@ -0,0 +1,267 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/iafan/hc/lib/util"
+)
+
+// Schema maps names of fields to extract to their definitions
+type Schema map[string]*Field
+
+// Field defines how to extract a value: elements are matched by a CSS
+// selector or an XPath expression (relative to the parent field's
+// element), and the value is their text, HTML, an attribute, or an object
+// of nested fields; a string can be used instead of a definition
+// as a shorthand for a CSS selector
+type Field struct {
+	Selector string `yaml:"selector"`
+	XPath    string `yaml:"xpath"`
+	Attr     string `yaml:"attr"`
+	Extract  string `yaml:"extract"`
+	List     bool   `yaml:"list"`
+	Regex    string `yaml:"regex"`
+	Type     string `yaml:"type"`
+	Fields   Schema `yaml:"fields"`
+}
+
+// scriptField is a field passed to the extraction script
+type scriptField struct {
+	Name     string         `json:"name"`
+	Selector string         `json:"selector,omitempty"`
+	XPath    string         `json:"xpath,omitempty"`
+	Attr     string         `json:"attr,omitempty"`
+	Extract  string         `json:"extract,omitempty"`
+	List     bool           `json:"list,omitempty"`
+	Regex    string         `json:"regex,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Fields   []*scriptField `json:"fields,omitempty"`
+}
+
+// UnmarshalYAML allows a CSS selector string instead of a definition
+func (f *Field) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var selector string
+	if unmarshal(&selector) == nil {
+		f.Selector = selector
+		return nil
+	}
+
+	type field Field
+	return unmarshal((*field)(f))
+}
+
+// ReadSchema reads and validates the schema from a YAML or JSON file;
+// '-' means STDIN
+func ReadSchema(filename string) (schema Schema, err error) {
+	data, err := util.ReadInputFile(filename, "the schema file")
+	if err != nil {
+		return
+	}
+
+	err = yaml.UnmarshalStrict(data, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse schema file: %v", err)
+	}
+
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("Schema has no fields")
+	}
+
+	err = schema.validate("")
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s Schema) validate(prefix string) error {
+	for name, f := range s {
+		if f == nil {
+			return fmt.Errorf("Field '%s%s' has no definition", prefix, name)
+		}
+
+		err := f.validate()
+		if err != nil {
+			return fmt.Errorf("Field '%s%s': %v", prefix, name, err)
+		}
+
+		err = f.Fields.validate(prefix + name + ".")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Field) validate() error {
+	if f.Selector != "" && f.XPath != "" {
+		return fmt.Errorf("only one of 'selector' or 'xpath' can be used")
+	}
+
+	switch f.Extract {
+	case "", "text", "html", "outer-html":
+		break
+	default:
+		return fmt.Errorf("unknown 'extract' value: '%s'. Available values: 'text', 'html' or 'outer-html'", f.Extract)
+	}
+
+	if f.Attr != "" && f.Extract != "" {
+		return fmt.Errorf("only one of 'attr' or 'extract' can be used")
+	}
+
+	switch f.Type {
+	case "", "string", "number", "integer", "boolean":
+		break
+	default:
+		return fmt.Errorf("unknown type: '%s'. Available types: 'string', 'number', 'integer' or 'boolean'", f.Type)
+	}
+
+	if len(f.Fields) > 0 && (f.Attr != "" || f.Extract != "" || f.Regex != "" || f.Type != "") {
+		return fmt.Errorf("'fields' can't be combined with 'attr', 'extract', 'regex' or 'type'")
+	}
+	return nil
+}
+
+// scriptFields converts the schema to the argument
+// of the extraction script, with fields sorted by name
+func (s Schema) scriptFields() []*scriptField {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]*scriptField, 0, len(names))
+	for _, name := range names {
+		f := s[name]
+		fields = append(fields, &scriptField{
+			Name:     name,
+			Selector: f.Selector,
+			XPath:    f.XPath,
+			Attr:     f.Attr,
+			Extract:  f.Extract,
+			List:     f.List,
+			Regex:    f.Regex,
+			Type:     f.Type,
+			Fields:   f.Fields.scriptFields(),
+		})
+	}
+	return fields
+}
+
+// Script returns the JavaScript expression that extracts the fields
+// from the page; its result is an object with the extracted 'data',
+// or with an 'error' if a regular expression is invalid
+func (s Schema) Script() (string, error) {
+	fields, err := json.Marshal(s.scriptFields())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s)(%s)", extractScript, fields), nil
+}
+
+// extractScript is a function that extracts the fields
+// (see scriptField) from the document
+const extractScript = `function(fields) {
+	function query(root, f) {
+		if (f.xpath) {
+			var r = document.evaluate(f.xpath, root, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+			var nodes = [];
+			for (var i = 0; i < r.snapshotLength; i++) {
+				nodes.push(r.snapshotItem(i));
+			}
+			return f.list ? nodes : nodes.slice(0, 1);
+		}
+		if (f.selector) {
+			if (f.list) {
+				return Array.prototype.slice.call(root.querySelectorAll(f.selector));
+			}
+			var node = root.querySelector(f.selector);
+			return node ? [node] : [];
+		}
+		return [root];
+	}
+
+	// regular expressions are compiled before extracting anything,
+	// as their syntax is only known to the browser
+	function compile(fields, prefix) {
+		for (var i = 0; i < fields.length; i++) {
+			var f = fields[i];
+			if (f.regex) {
+				try {
+					f.re = new RegExp(f.regex);
+				} catch (e) {
+					return "Field '" + prefix + f.name + "': invalid 'regex': " + e.message;
+				}
+			}
+			var err = f.fields && compile(f.fields, prefix + f.name + '.');
+			if (err) {
+				return err;
+			}
+		}
+		return null;
+	}
+
+	function convert(value, f) {
+		if (value === null || value === undefined) {
+			return null;
+		}
+		if (f.re) {
+			var m = f.re.exec(value);
+			if (!m) {
+				return null;
+			}
+			value = m.length > 1 ? m[1] : m[0];
+		}
+		switch (f.type) {
+		case 'number':
+		case 'integer':
+			var n = parseFloat(String(value).replace(/[^0-9.eE+-]/g, ''));
+			if (isNaN(n)) {
+				return null;
+			}
+			return f.type === 'integer' ? Math.trunc(n) : n;
+		case 'boolean':
+			value = String(value).trim().toLowerCase();
+			return value !== '' && value !== 'false' && value !== '0' && value !== 'no';
+		}
+		return value;
+	}
+
+	function value(node, f) {
+		if (f.fields) {
+			return extract(node, f.fields);
+		}
+		if (f.attr) {
+			return convert(node.getAttribute ? node.getAttribute(f.attr) : null, f);
+		}
+		if (f.extract === 'html') {
+			return convert(node.innerHTML, f);
+		}
+		if (f.extract === 'outer-html') {
+			return convert(node.outerHTML, f);
+		}
+		return convert((node.textContent || '').replace(/\s+/g, ' ').trim(), f);
+	}
+
+	function extract(root, fields) {
+		var result = {};
+		fields.forEach(function(f) {
+			var values = query(root, f).map(function(node) {
+				return value(node, f);
+			});
+			result[f.name] = f.list ? values : (values.length ? values[0] : null);
+		});
+		return result;
+	}
+
+	var err = compile(fields, '');
+	if (err) {
+		return {error: err};
+	}
+	return {data: extract(document, fields)};
+}`
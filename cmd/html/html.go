@@ -1,11 +1,15 @@
 package html
 
 import (
+	"context"
 	"flag"
 	"os"
 
+	"github.com/raff/godet"
+
 	"github.com/iafan/hc/cmd/eval"
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/util"
 )
 
 // Command implements 'load' command
@@ -28,6 +32,7 @@ func (c *Command) ShowHelp() {
 Usage:
 
 	hc html [options] <URL>
+	hc html [options] --urls-file <file> --output-file <template>
 	hc html --help
 
 Available options:
@@ -47,16 +52,34 @@ func (c *Command) Init(host lib.Host) {
 
 // Validate implements Command.Validate
 func (c *Command) Validate(args []string) {
-	if len(args) != 1 {
-		os.Stderr.WriteString("Usage: hc html [options] <URL>\n")
-		os.Stderr.WriteString("       hc html --help\n")
-		os.Exit(2)
+	// in batch mode, URLs are read from the file
+	nargs := 1
+	if c.host.IsBatch() {
+		nargs = 0
+	}
+
+	if len(args) != nargs {
+		util.StopOnUsageError(
+			"Usage: hc html [options] <URL>\n" +
+				"       hc html [options] --urls-file <file> --output-file <template>\n" +
+				"       hc html --help\n",
+		)
 	}
 
-	c.eval.Validate([]string{args[0], "return document.documentElement.outerHTML"})
+	c.eval.Validate(append(args, "return document.documentElement.outerHTML"))
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.eval.GetURL()
 }
 
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
-	return c.eval.Run(outfile)
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	return c.eval.Run(ctx, outfile)
+}
+
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
+	return c.eval.RunURL(ctx, host, remote, url, outfile)
 }
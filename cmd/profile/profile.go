@@ -0,0 +1,143 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/profile"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Command implements 'profile' command
+type Command struct {
+	host lib.Host
+
+	action  string
+	name    string
+	archive string
+}
+
+// GetDescription implements Command.GetDescription
+func (c *Command) GetDescription() string {
+	return "Manage persistent browser profiles"
+}
+
+// ShowHelp implements Command.ShowHelp
+func (c *Command) ShowHelp() {
+	os.Stderr.WriteString(`Description:
+
+	Manage persistent browser profiles. A profile keeps cookies,
+	storage and cache between runs of page-loading commands
+	started with '--profile <name>' flag. A profile can't be used
+	by more than one command at a time.
+
+	Profiles are stored in the directory defined by HC_PROFILES_DIR
+	environment variable (~/.hc/profiles by default)
+
+Usage:
+
+	hc profile list
+	hc profile create <name>
+	hc profile delete <name>
+	hc profile export <name>
+	hc profile import <name> [<archive-file>]
+	hc profile --help
+
+	'export' outputs the profile as a .tar.gz archive;
+	'import' reads the archive from the file or from STDIN
+
+`)
+}
+
+// Init implements Command.Init
+func (c *Command) Init(host lib.Host) {
+	c.host = host
+}
+
+// Validate implements Command.Validate
+func (c *Command) Validate(args []string) {
+	if len(args) == 0 {
+		c.usage()
+	}
+
+	c.action = args[0]
+
+	switch c.action {
+	case "list":
+		if len(args) != 1 {
+			c.usage()
+		}
+		return
+	case "create", "delete", "export":
+		if len(args) != 2 {
+			c.usage()
+		}
+	case "import":
+		if len(args) < 2 || len(args) > 3 {
+			c.usage()
+		}
+		if len(args) == 3 {
+			c.archive = args[2]
+		}
+	default:
+		util.StopOnUsageError(fmt.Sprintf(
+			"Unknown action: '%s'. Available actions: 'list', 'create', 'delete', 'export' or 'import'\n",
+			c.action,
+		))
+	}
+
+	c.name = args[1]
+
+	err := profile.ValidateName(c.name)
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+}
+
+func (c *Command) usage() {
+	util.StopOnUsageError(
+		"Usage: hc profile list|create|delete|export|import [<name>] [<archive-file>]\n" +
+			"       hc profile --help\n",
+	)
+}
+
+// Run implements Command.Run
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	switch c.action {
+	case "list":
+		var names []string
+		names, err = profile.List()
+		if err != nil {
+			return
+		}
+		for _, name := range names {
+			outfile.WriteString(name + "\n")
+		}
+
+	case "create":
+		err = profile.Create(c.name)
+
+	case "delete":
+		err = profile.Delete(c.name)
+
+	case "export":
+		err = profile.Export(c.name, outfile)
+
+	case "import":
+		var r io.Reader = os.Stdin
+		if c.archive != "" {
+			var f *os.File
+			f, err = os.Open(c.archive)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			r = f
+		}
+		err = profile.Import(c.name, r)
+	}
+	return
+}
@@ -1,37 +1,35 @@
 package resource
 
 import (
+	"context"
 	"encoding/base64"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/raff/godet"
 
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
 )
 
 // Command implements 'load' command
 type Command struct {
 	host lib.Host
 
-	blockedURLsParam string
-	blockedURLs      []string
-	resourceMatch    string
-	matchMode        string
-	matchIsContains  bool
-	matchIsPrefix    bool
-	matchIsRegex     bool
-	matchIdx         uint
-	url              string
-	wait             time.Duration
-
-	reMatch *regexp.Regexp
+	loader        loader.Loader
+	resourceMatch string
+	matchMode     string
+	matchIdx      uint
+	url           string
+	wait          time.Duration
+
+	matcher *util.URLMatcher
 }
 
 // GetDescription implements Command.GetDescription
@@ -49,6 +47,7 @@ func (c *Command) ShowHelp() {
 Usage:
 
 	hc resource [options] <URL> <resource-URL-mask>
+	hc resource [options] --urls-file <file> --output-file <template> <resource-URL-mask>
 	hc resource --help
 
 Available options:
@@ -66,111 +65,67 @@ func (c *Command) Init(host lib.Host) {
 	flag.UintVar(&c.matchIdx, "match-index", 0, "Match only index-th resource out of qualified ones")
 	flag.DurationVar(&c.wait, "wait", 500*time.Millisecond, "Extra time to wait before capturing data")
 
-	flag.StringVar(
-		&c.blockedURLsParam,
-		"blocked-urls",
-		"",
-		"Comma-separated list of file masks to block from loading",
-	)
+	c.loader.InitNavigation(host)
 }
 
 // Validate implements Command.Validate
 func (c *Command) Validate(args []string) {
-	if c.blockedURLsParam != "" {
-		c.blockedURLs = strings.Split(c.blockedURLsParam, ",")
+	c.loader.Validate()
+
+	// in batch mode, URLs are read from the file
+	nargs := 2
+	if c.host.IsBatch() {
+		nargs = 1
 	}
 
-	if len(args) != 2 {
-		os.Stderr.WriteString("Usage: hc resource [options] <URL> <resource-URL-mask>\n")
-		os.Stderr.WriteString("       hc resource --help\n")
-		os.Exit(2)
+	if len(args) != nargs {
+		util.StopOnUsageError(
+			"Usage: hc resource [options] <URL> <resource-URL-mask>\n" +
+				"       hc resource [options] --urls-file <file> --output-file <template> <resource-URL-mask>\n" +
+				"       hc resource --help\n",
+		)
 	}
 
-	c.url = args[0]
-	c.resourceMatch = args[1]
-
-	switch c.matchMode {
-	case "exact":
-		break
-	case "prefix":
-		c.matchIsPrefix = true
-	case "contains":
-		c.matchIsContains = true
-	case "regexp":
-		c.matchIsRegex = true
-	default:
-		os.Stderr.WriteString(fmt.Sprintf(
-			"Unknown match mode: '%s'. Available modes: 'contains', 'exact', 'prefix' or 'regexp'\n",
-			c.matchMode,
-		))
-		os.Exit(2)
+	if nargs == 2 {
+		c.url = args[0]
 	}
+	c.resourceMatch = args[nargs-1]
 
-	if c.matchIsRegex {
-		var err error
-		c.reMatch, err = regexp.Compile(c.resourceMatch)
-		if err != nil {
-			os.Stderr.WriteString(fmt.Sprintf(
-				"Failed to compile the regular expression: %s\n",
-				err,
-			))
-			os.Exit(2)
-		}
+	var err error
+	c.matcher, err = util.NewURLMatcher(c.matchMode, c.resourceMatch)
+	if err != nil {
+		util.StopOnUsageError(err.Error())
 	}
 }
 
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
+}
+
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
-	remote, err := c.host.ConnectToRemote()
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
 	if err != nil {
 		return
 	}
 	defer c.host.DisconnectFromRemote()
 
-	verbose := c.host.GetVerbose()
-
-	// block resource loading
-	if len(c.blockedURLs) > 0 {
-		err = remote.SetBlockedURLs(c.blockedURLs...)
-		if err != nil {
-			return
-		}
-	}
-
-	// create new tab
-	/*
-		_, err = remote.NewTab(c.url)
-		if err != nil {
-			return
-		}
-	*/
-	//_, err = remote.Navigate(c.url)
-
-	/*
-		err = remote.ActivateTab(tab)
-		if err != nil {
-			return
-		}
-	*/
-
-	remote.NetworkEvents(true)
-	if err != nil {
-		return
-	}
+	return c.RunURL(ctx, c.host, remote, c.url, outfile)
+}
 
-	_, err = remote.Navigate(c.url)
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
+	verbose := host.GetVerbose()
+	matchIdx := c.matchIdx
+	pageLoader := c.loader.Clone(host)
 
 	status := make(chan bool, 2)
 	result := false
 
-	exitErr := fmt.Errorf("Request timed out")
-
-	go func() {
-		time.Sleep(c.host.GetDeadline())
-		status <- false
-	}()
+	var exitErr error
 
-	remote.CallbackEvent("Network.responseReceived", func(params godet.Params) {
+	util.AddEventListener(remote, "Network.responseReceived", func(params godet.Params) {
 		resp := params["response"].(map[string]interface{})
 		respURL := resp["url"].(string)
 		if verbose {
@@ -178,20 +133,10 @@ func (c *Command) Run(outfile *os.File) (err error) {
 			log.Printf("Loaded %s (%s)", respURL, mime)
 		}
 
-		matched := false
-		if c.matchIsRegex {
-			matched = c.reMatch.MatchString(respURL)
-		} else if c.matchIsPrefix {
-			matched = strings.HasPrefix(respURL, c.resourceMatch)
-		} else if c.matchIsContains {
-			matched = strings.Contains(respURL, c.resourceMatch)
-		} else {
-			// exact match
-			matched = respURL == c.resourceMatch
-		}
+		matched := c.matcher.Match(respURL)
 
-		if matched && c.matchIdx > 0 {
-			c.matchIdx--
+		if matched && matchIdx > 0 {
+			matchIdx--
 			matched = false
 		}
 
@@ -248,11 +193,21 @@ func (c *Command) Run(outfile *os.File) (err error) {
 		}
 	})
 
-	result = <-status
+	page, err := pageLoader.Navigate(ctx, remote, url)
+	if err != nil {
+		return
+	}
+	defer page.Close()
+
+	select {
+	case result = <-status:
+	case <-ctx.Done():
+		return util.ContextError(ctx)
+	}
 
 	if !result {
 		return exitErr
 	}
 
-	return
+	return pageLoader.CheckResponse(page)
 }
@@ -0,0 +1,256 @@
+package run
+
+import (
+	"fmt"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/iafan/hc/lib/util"
+)
+
+const (
+	defaultStepTimeout = 10 * time.Second
+	defaultTypeDelay   = 50 * time.Millisecond
+)
+
+// Flow is a declarative sequence of steps executed against
+// a single browser session
+type Flow struct {
+	URL       string  `yaml:"url"`
+	StopEvent string  `yaml:"stop-event"`
+	Timeout   string  `yaml:"timeout"`
+	Steps     []*Step `yaml:"steps"`
+
+	timeout time.Duration
+}
+
+// Step is a single flow step; exactly one action field must be set
+type Step struct {
+	Name    string `yaml:"name"`
+	Timeout string `yaml:"timeout"`
+
+	Navigate        string          `yaml:"navigate"`
+	WaitForSelector string          `yaml:"wait-for-selector"`
+	Click           string          `yaml:"click"`
+	Type            *TypeStep       `yaml:"type"`
+	Select          *SelectStep     `yaml:"select"`
+	Press           string          `yaml:"press"`
+	Scroll          *ScrollStep     `yaml:"scroll"`
+	Eval            string          `yaml:"eval"`
+	Screenshot      *ScreenshotStep `yaml:"screenshot"`
+	CaptureResource *CaptureStep    `yaml:"capture-resource"`
+	Assert          string          `yaml:"assert"`
+	Sleep           string          `yaml:"sleep"`
+
+	action  string
+	timeout time.Duration
+	sleep   time.Duration
+}
+
+// TypeStep clicks an element and types text into it
+type TypeStep struct {
+	Selector string `yaml:"selector"`
+	Text     string `yaml:"text"`
+	Delay    string `yaml:"delay"`
+
+	delay time.Duration
+}
+
+// SelectStep selects an option of a <select> element by its value
+type SelectStep struct {
+	Selector string `yaml:"selector"`
+	Value    string `yaml:"value"`
+}
+
+// ScrollStep scrolls an element into view, or scrolls the page
+// to the given coordinates
+type ScrollStep struct {
+	Selector string `yaml:"selector"`
+	X        int    `yaml:"x"`
+	Y        int    `yaml:"y"`
+}
+
+// ScreenshotStep captures a screenshot of the viewport (or of the entire page)
+// and saves it to a file or, if no file is given, to the flow outputs
+type ScreenshotStep struct {
+	File     string `yaml:"file"`
+	FullPage bool   `yaml:"full-page"`
+}
+
+// CaptureStep waits for a resource matching the URL mask
+// and saves its content to the flow outputs
+type CaptureStep struct {
+	URL   string `yaml:"url"`
+	Match string `yaml:"match"`
+
+	matcher *util.URLMatcher
+}
+
+// ReadFlow reads and validates the flow from a YAML or JSON file;
+// '-' means STDIN
+func ReadFlow(filename string) (flow *Flow, err error) {
+	data, err := util.ReadInputFile(filename, "the flow file")
+	if err != nil {
+		return
+	}
+
+	flow = &Flow{}
+	err = yaml.UnmarshalStrict(data, flow)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse flow file: %v", err)
+	}
+
+	err = flow.validate()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (f *Flow) validate() (err error) {
+	if f.StopEvent == "" {
+		f.StopEvent = "networkIdle"
+	}
+
+	f.timeout, err = parseDuration(f.Timeout, defaultStepTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid flow timeout: %v", err)
+	}
+
+	if f.URL != "" {
+		f.Steps = append([]*Step{{Navigate: f.URL}}, f.Steps...)
+	}
+
+	if len(f.Steps) == 0 {
+		return fmt.Errorf("Flow has no steps")
+	}
+
+	// without a top-level URL, the first navigate step provides
+	// the page URL (e.g. for cookies without a domain)
+	if f.URL == "" {
+		for _, step := range f.Steps {
+			if step.Navigate != "" {
+				f.URL = step.Navigate
+				break
+			}
+		}
+	}
+	if f.URL == "" {
+		return fmt.Errorf("Flow has no URL: specify 'url' or add a 'navigate' step")
+	}
+
+	for i, step := range f.Steps {
+		err = step.validate(f.timeout)
+		if err != nil {
+			return fmt.Errorf("Step #%d: %v", i+1, err)
+		}
+	}
+	return
+}
+
+func (s *Step) validate(defaultTimeout time.Duration) (err error) {
+	actions := []struct {
+		name string
+		set  bool
+	}{
+		{"navigate", s.Navigate != ""},
+		{"wait-for-selector", s.WaitForSelector != ""},
+		{"click", s.Click != ""},
+		{"type", s.Type != nil},
+		{"select", s.Select != nil},
+		{"press", s.Press != ""},
+		{"scroll", s.Scroll != nil},
+		{"eval", s.Eval != ""},
+		{"screenshot", s.Screenshot != nil},
+		{"capture-resource", s.CaptureResource != nil},
+		{"assert", s.Assert != ""},
+		{"sleep", s.Sleep != ""},
+	}
+
+	for _, a := range actions {
+		if !a.set {
+			continue
+		}
+		if s.action != "" {
+			return fmt.Errorf("Only one action per step is allowed, got '%s' and '%s'", s.action, a.name)
+		}
+		s.action = a.name
+	}
+
+	if s.action == "" {
+		return fmt.Errorf("No action defined")
+	}
+
+	s.timeout, err = parseDuration(s.Timeout, defaultTimeout)
+	if err != nil {
+		return fmt.Errorf("Invalid timeout: %v", err)
+	}
+
+	switch s.action {
+	case "type":
+		if s.Type.Selector == "" {
+			return fmt.Errorf("'type' action requires 'selector'")
+		}
+		s.Type.delay, err = parseDuration(s.Type.Delay, defaultTypeDelay)
+		if err != nil {
+			return fmt.Errorf("Invalid typing delay: %v", err)
+		}
+	case "select":
+		if s.Select.Selector == "" {
+			return fmt.Errorf("'select' action requires 'selector'")
+		}
+	case "capture-resource":
+		if s.CaptureResource.URL == "" {
+			return fmt.Errorf("'capture-resource' action requires 'url'")
+		}
+		if s.CaptureResource.Match == "" {
+			s.CaptureResource.Match = "exact"
+		}
+		s.CaptureResource.matcher, err = util.NewURLMatcher(s.CaptureResource.Match, s.CaptureResource.URL)
+		if err != nil {
+			return
+		}
+	case "sleep":
+		s.sleep, err = time.ParseDuration(s.Sleep)
+		if err != nil {
+			return fmt.Errorf("Invalid sleep duration: %v", err)
+		}
+	}
+	return
+}
+
+// Description returns a human-readable description of the step
+func (s *Step) Description() string {
+	var arg string
+	switch s.action {
+	case "navigate":
+		arg = s.Navigate
+	case "wait-for-selector":
+		arg = s.WaitForSelector
+	case "click":
+		arg = s.Click
+	case "type":
+		arg = s.Type.Selector
+	case "select":
+		arg = s.Select.Selector
+	case "press":
+		arg = s.Press
+	case "capture-resource":
+		arg = s.CaptureResource.URL
+	case "sleep":
+		arg = s.Sleep
+	}
+
+	if arg == "" {
+		return s.action
+	}
+	return fmt.Sprintf("%s '%s'", s.action, arg)
+}
+
+func parseDuration(s string, defaultValue time.Duration) (time.Duration, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(s)
+}
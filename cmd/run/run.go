@@ -0,0 +1,147 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Command implements 'run' command
+type Command struct {
+	host lib.Host
+
+	loader loader.Loader
+	flow   *Flow
+}
+
+// GetDescription implements Command.GetDescription
+func (c *Command) GetDescription() string {
+	return "Run a scripted multi-step interaction flow"
+}
+
+// ShowHelp implements Command.ShowHelp
+func (c *Command) ShowHelp() {
+	os.Stderr.WriteString(`Description:
+
+	Execute a declarative sequence of steps (defined in a YAML
+	or JSON file) against a single browser session, and output
+	a JSON result with per-step status and collected outputs.
+	Use '-' as a file name to read the flow from STDIN.
+
+	Flow file example:
+
+		url: https://example.com/login  # initial page (optional if
+		                                # there's a navigate step)
+		stop-event: networkIdle         # event to wait for after navigation
+		timeout: 10s                    # default per-step timeout
+		steps:
+		  - type: {selector: "#user", text: "john"}
+		  - type: {selector: "#password", text: "secret"}
+		  - click: "#submit"
+		  - wait-for-selector: ".welcome"
+		  - name: title
+		    eval: "return document.title"
+		  - assert: "location.pathname === '/account'"
+		  - screenshot: {file: "account.png", full-page: true}
+
+	Available actions: navigate, wait-for-selector, click, type,
+	select, press, scroll, eval, screenshot, capture-resource,
+	assert and sleep. Each step can have its own 'timeout'; 'name'
+	defines the key under which step output is saved.
+
+	Execution stops at the first failed step; the result is still
+	written (including to --output-file), and the command fails
+	with the error of the step
+
+Usage:
+
+	hc run [options] <flow-file>
+	hc run --help
+
+Available options:
+
+`)
+
+	flag.PrintDefaults()
+}
+
+// Init implements Command.Init
+func (c *Command) Init(host lib.Host) {
+	c.host = host
+
+	c.loader.InitNavigation(host)
+}
+
+// Validate implements Command.Validate
+func (c *Command) Validate(args []string) {
+	c.loader.Validate()
+
+	if len(args) != 1 {
+		util.StopOnUsageError(
+			"Usage: hc run [options] <flow-file>\n" +
+				"       hc run --help\n",
+		)
+	}
+
+	var err error
+	c.flow, err = ReadFlow(args[0])
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+
+	// screenshot file names support the same macros as --output-file
+	for i, step := range c.flow.Steps {
+		if step.Screenshot == nil || step.Screenshot.File == "" {
+			continue
+		}
+
+		err = c.host.ValidateFileName(step.Screenshot.File)
+		if err != nil {
+			util.StopOnUsageError(fmt.Sprintf("Step #%d: invalid screenshot file name: %v", i+1, err))
+		}
+	}
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.flow.URL
+}
+
+// Run implements Command.Run
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
+	if err != nil {
+		return
+	}
+	defer c.host.DisconnectFromRemote()
+
+	err = c.loader.Prepare(remote, c.flow.URL)
+	if err != nil {
+		return
+	}
+
+	r := newRunner(c.host, remote, &c.loader, c.flow)
+	err = r.start()
+	if err != nil {
+		return
+	}
+
+	result, err := r.run(ctx)
+
+	enc := json.NewEncoder(outfile)
+	enc.SetIndent("", "  ")
+	err2 := enc.Encode(result)
+	if err2 != nil {
+		return err2
+	}
+
+	// the result reports failed steps, so it's written
+	// to the output file even if the flow fails
+	return util.WithOutput(err)
+}
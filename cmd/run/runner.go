@@ -0,0 +1,390 @@
+package run
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// Result is the JSON result of the flow execution
+type Result struct {
+	Success bool                   `json:"success"`
+	Steps   []*StepResult          `json:"steps"`
+	Outputs map[string]interface{} `json:"outputs"`
+}
+
+// StepResult is the result of a single step execution;
+// duration is in milliseconds
+type StepResult struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Action   string `json:"action"`
+	Status   string `json:"status"`
+	Duration int64  `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type response struct {
+	requestID string
+	url       string
+	finished  bool
+	captured  bool
+}
+
+// runner executes flow steps against a single browser session
+type runner struct {
+	host    lib.Host
+	remote  *godet.RemoteDebugger
+	loader  *loader.Loader
+	flow    *Flow
+	verbose bool
+
+	mutex     sync.Mutex
+	responses []*response
+	requests  map[string]*response
+
+	outputs map[string]interface{}
+}
+
+func newRunner(host lib.Host, remote *godet.RemoteDebugger, pageLoader *loader.Loader, flow *Flow) *runner {
+	return &runner{
+		host:     host,
+		remote:   remote,
+		loader:   pageLoader,
+		flow:     flow,
+		verbose:  host.GetVerbose(),
+		requests: make(map[string]*response),
+		outputs:  make(map[string]interface{}),
+	}
+}
+
+// start subscribes to network events
+func (r *runner) start() (err error) {
+	util.AddEventListener(r.remote, "Network.responseReceived", func(params godet.Params) {
+		resp, _ := params["response"].(map[string]interface{})
+		requestID, _ := params["requestId"].(string)
+		url, _ := resp["url"].(string)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		res := &response{requestID: requestID, url: url}
+		r.responses = append(r.responses, res)
+		r.requests[requestID] = res
+	})
+
+	util.AddEventListener(r.remote, "Network.loadingFinished", func(params godet.Params) {
+		requestID, _ := params["requestId"].(string)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if res := r.requests[requestID]; res != nil {
+			res.finished = true
+		}
+	})
+
+	return r.remote.NetworkEvents(true)
+}
+
+// run executes all steps and returns the result; execution stops
+// at the first failed step (or when the context is done),
+// and the remaining steps are skipped
+func (r *runner) run(ctx context.Context) (result *Result, err error) {
+	result = &Result{Success: true, Outputs: r.outputs}
+
+	for i, step := range r.flow.Steps {
+		sr := &StepResult{
+			Index:  i + 1,
+			Name:   step.Name,
+			Action: step.action,
+			Status: "skipped",
+		}
+		result.Steps = append(result.Steps, sr)
+
+		if err != nil {
+			continue
+		}
+
+		if r.verbose {
+			log.Printf("Step #%d: %s", i+1, step.Description())
+		}
+
+		start := time.Now()
+		stepErr := r.runStep(ctx, i, step)
+		sr.Duration = int64(time.Since(start) / time.Millisecond)
+
+		if stepErr != nil {
+			sr.Status = "failed"
+			sr.Error = stepErr.Error()
+			result.Success = false
+			err = fmt.Errorf("Step #%d (%s) failed: %w", i+1, step.Description(), stepErr)
+			continue
+		}
+		sr.Status = "ok"
+	}
+	return
+}
+
+func (r *runner) runStep(ctx context.Context, idx int, step *Step) error {
+	if ctx.Err() != nil {
+		return util.ContextError(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, step.timeout)
+	defer cancel()
+
+	key := step.Name
+	if key == "" {
+		key = fmt.Sprintf("step%d", idx+1)
+	}
+
+	switch step.action {
+	case "navigate":
+		return r.navigate(ctx, step.Navigate)
+
+	case "wait-for-selector":
+		return r.waitForSelector(ctx, step.WaitForSelector)
+
+	case "click":
+		err := r.waitForSelector(ctx, step.Click)
+		if err != nil {
+			return err
+		}
+		return util.ClickElement(ctx, r.remote, step.Click)
+
+	case "type":
+		err := r.waitForSelector(ctx, step.Type.Selector)
+		if err != nil {
+			return err
+		}
+		err = util.ClickElement(ctx, r.remote, step.Type.Selector)
+		if err != nil {
+			return err
+		}
+		return util.TypeText(ctx, r.remote, step.Type.Text, step.Type.delay)
+
+	case "select":
+		return r.withElement(ctx, step.Select.Selector, fmt.Sprintf(`
+			const value = %s;
+			if (!Array.from(el.options || []).some(o => o.value === value)) {
+				throw new Error('No option with value "' + value + '"');
+			}
+			el.value = value;
+			el.dispatchEvent(new Event('input', {bubbles: true}));
+			el.dispatchEvent(new Event('change', {bubbles: true}));
+		`, util.JSString(step.Select.Value)))
+
+	case "press":
+		return util.PressKey(ctx, r.remote, step.Press)
+
+	case "scroll":
+		if step.Scroll.Selector != "" {
+			return r.withElement(ctx, step.Scroll.Selector, `
+				el.scrollIntoView({block: 'center'});
+			`)
+		}
+		_, err := util.EvaluateFunc(ctx, r.remote, fmt.Sprintf("window.scrollTo(%d, %d)", step.Scroll.X, step.Scroll.Y))
+		return err
+
+	case "eval":
+		// only exceptions thrown by the code are script errors
+		res, err := util.Evaluate(
+			ctx, &util.ExecutionContext{Session: r.remote}, fmt.Sprintf("(function() {\n%s\n})()", step.Eval),
+		)
+		if err != nil {
+			return err
+		}
+		r.outputs[key] = res
+		return nil
+
+	case "screenshot":
+		return r.screenshot(ctx, key, step.Screenshot)
+
+	case "capture-resource":
+		return r.captureResource(ctx, key, step.CaptureResource)
+
+	case "assert":
+		ok, err := r.evalBool(ctx, fmt.Sprintf("return !!(%s)", step.Assert))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Assertion failed: %s", step.Assert)
+		}
+		return nil
+
+	case "sleep":
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < step.sleep {
+			return fmt.Errorf("Sleep duration exceeds the deadline")
+		}
+		return util.Sleep(ctx, step.sleep)
+	}
+
+	return fmt.Errorf("Unknown action: '%s'", step.action)
+}
+
+func (r *runner) navigate(ctx context.Context, url string) error {
+	page, err := r.loader.Navigate(ctx, r.remote, url)
+	if err != nil {
+		return err
+	}
+	defer page.Close()
+
+	err = page.WaitForEvent(ctx, r.flow.StopEvent)
+	if err != nil {
+		if ctx.Err() != nil {
+			return waitError(ctx, fmt.Sprintf("'%s' event", r.flow.StopEvent))
+		}
+		return err
+	}
+
+	return r.loader.CheckResponse(page)
+}
+
+// poll calls fn until it returns true or an error, or until the context is done
+func (r *runner) poll(ctx context.Context, what string, fn func() (bool, error)) error {
+	for {
+		ok, err := fn()
+		if err != nil {
+			if ctx.Err() != nil {
+				return waitError(ctx, what)
+			}
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if util.Sleep(ctx, pollInterval) != nil {
+			return waitError(ctx, what)
+		}
+	}
+}
+
+// waitError describes why waiting for something has stopped
+func waitError(ctx context.Context, what string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return util.Timeoutf("Timed out waiting for %s", what)
+	}
+	return util.ContextError(ctx)
+}
+
+func (r *runner) evalBool(ctx context.Context, expr string) (bool, error) {
+	res, err := util.EvaluateFunc(ctx, r.remote, expr)
+	if err != nil {
+		return false, err
+	}
+	ok, _ := res.(bool)
+	return ok, nil
+}
+
+func (r *runner) waitForSelector(ctx context.Context, selector string) error {
+	expr := fmt.Sprintf("return document.querySelector(%s) !== null", util.JSString(selector))
+	return r.poll(ctx, fmt.Sprintf("element '%s'", selector), func() (bool, error) {
+		return r.evalBool(ctx, expr)
+	})
+}
+
+// withElement waits for the element matching the selector to appear
+// and runs the script with the element available as `el`
+func (r *runner) withElement(ctx context.Context, selector string, script string) error {
+	err := r.waitForSelector(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	_, err = util.EvaluateFunc(ctx, r.remote, fmt.Sprintf(
+		"const el = document.querySelector(%s);\n%s",
+		util.JSString(selector), script,
+	))
+	return err
+}
+
+func (r *runner) screenshot(ctx context.Context, key string, s *ScreenshotStep) (err error) {
+	var data []byte
+	if s.FullPage {
+		var res interface{}
+		res, err = util.EvaluateFunc(
+			ctx, r.remote, "return [document.documentElement.scrollWidth, document.documentElement.scrollHeight]",
+		)
+		if err != nil {
+			return
+		}
+		size, _ := res.([]interface{})
+		if len(size) != 2 {
+			return fmt.Errorf("Failed to get document size")
+		}
+		width, _ := size[0].(float64)
+		height, _ := size[1].(float64)
+		data, err = util.CaptureFullPageScreenshot(ctx, r.remote, int(width), int(height))
+	} else {
+		data, err = util.CaptureScreenshot(ctx, r.remote)
+	}
+	if err != nil {
+		return
+	}
+
+	if s.File != "" {
+		filename := r.host.ExpandFileName(s.File)
+		if r.verbose {
+			log.Printf("Saving screenshot to [%s]", filename)
+		}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err == nil {
+			r.outputs[key] = filename
+		}
+		return
+	}
+
+	r.outputs[key] = base64.StdEncoding.EncodeToString(data)
+	return
+}
+
+func (r *runner) captureResource(ctx context.Context, key string, c *CaptureStep) (err error) {
+	var matched *response
+	err = r.poll(ctx, fmt.Sprintf("resource matching %s", c.matcher), func() (bool, error) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		for _, res := range r.responses {
+			if !res.captured && res.finished && c.matcher.Match(res.url) {
+				res.captured = true
+				matched = res
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return
+	}
+
+	if r.verbose {
+		log.Printf("Capturing %s", matched.url)
+	}
+
+	body, err := util.GetResponseBody(ctx, r.remote, matched.requestID)
+	if err != nil {
+		return
+	}
+
+	if utf8.Valid(body) {
+		r.outputs[key] = string(body)
+	} else {
+		r.outputs[key] = base64.StdEncoding.EncodeToString(body)
+	}
+	return
+}
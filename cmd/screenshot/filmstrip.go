@@ -0,0 +1,246 @@
+package screenshot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib/util"
+)
+
+// filmstripFrame is a single captured frame of the filmstrip
+type filmstripFrame struct {
+	Time         int64   `json:"time"`
+	Completeness float64 `json:"visualCompleteness"`
+	Image        string  `json:"image"`
+
+	offset time.Duration
+	data   []byte
+	hist   *histogram
+}
+
+// filmstripReport is the JSON report produced in filmstrip mode;
+// all times are in milliseconds relative to the navigation start
+type filmstripReport struct {
+	URL               string            `json:"url"`
+	Interval          int64             `json:"interval"`
+	FirstVisualChange int64             `json:"firstVisualChange"`
+	SpeedIndex        int64             `json:"speedIndex"`
+	VisuallyComplete  int64             `json:"visuallyComplete"`
+	Frames            []*filmstripFrame `json:"frames"`
+}
+
+// filmstripRecorder captures viewport screenshots at fixed intervals
+type filmstripRecorder struct {
+	remote   *godet.RemoteDebugger
+	interval time.Duration
+	start    time.Time
+
+	mutex  sync.Mutex
+	frames []*filmstripFrame
+	err    error
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newFilmstripRecorder(remote *godet.RemoteDebugger, interval time.Duration) *filmstripRecorder {
+	return &filmstripRecorder{
+		remote:   remote,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts capturing frames in the background; the first frame
+// is captured immediately and is used as a reference for the initial state
+func (r *filmstripRecorder) Start() {
+	r.start = time.Now()
+
+	util.Go(func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if !r.capture() {
+				return
+			}
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// Stop stops the background capture and takes the final frame
+// which is used as a reference for the visually complete state;
+// subsequent calls only return the capture error
+func (r *filmstripRecorder) Stop() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+
+		if r.err == nil {
+			r.capture()
+		}
+	})
+	return r.err
+}
+
+func (r *filmstripRecorder) capture() bool {
+	offset := time.Since(r.start)
+
+	data, err := r.remote.CaptureScreenshot("png", 0, true)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err != nil {
+		r.err = err
+		return false
+	}
+
+	r.frames = append(r.frames, &filmstripFrame{
+		Time:   int64(offset / time.Millisecond),
+		offset: offset,
+		data:   data,
+	})
+	return true
+}
+
+// Report computes visual progress metrics and returns the final report
+func (r *filmstripRecorder) Report(url string) (report *filmstripReport, err error) {
+	for _, f := range r.frames {
+		f.hist, err = newHistogram(f.data)
+		if err != nil {
+			return
+		}
+	}
+
+	report = &filmstripReport{
+		URL:      url,
+		Interval: int64(r.interval / time.Millisecond),
+	}
+
+	if len(r.frames) == 0 {
+		return
+	}
+
+	first := r.frames[0]
+	last := r.frames[len(r.frames)-1]
+
+	firstChangeFound := false
+	completeIdx := 0
+
+	for i, f := range r.frames {
+		f.Completeness = f.hist.progress(first.hist, last.hist)
+
+		if !firstChangeFound && !f.hist.equals(first.hist) {
+			report.FirstVisualChange = f.Time
+			firstChangeFound = true
+		}
+
+		if f.Completeness < 1 {
+			completeIdx = i + 1
+		}
+	}
+
+	if completeIdx >= len(r.frames) {
+		completeIdx = len(r.frames) - 1
+	}
+	report.VisuallyComplete = r.frames[completeIdx].Time
+
+	// Speed Index is the area above the visual progress curve
+	// up to the moment the page becomes visually complete
+	speedIndex := 0.0
+	for i := 1; i <= completeIdx; i++ {
+		prev := r.frames[i-1]
+		dt := r.frames[i].offset - prev.offset
+		speedIndex += (1 - prev.Completeness) * float64(dt/time.Millisecond)
+	}
+	report.SpeedIndex = int64(speedIndex)
+
+	// only keep frames that differ from the previous one
+	var prev *filmstripFrame
+	for _, f := range r.frames {
+		if prev != nil && bytes.Equal(prev.data, f.data) {
+			continue
+		}
+		f.Completeness = float64(int64(f.Completeness*10000)) / 100 // percent
+		f.Image = base64.StdEncoding.EncodeToString(f.data)
+		report.Frames = append(report.Frames, f)
+		prev = f
+	}
+
+	return
+}
+
+// histogram holds per-channel (R, G, B) color histograms of a frame
+type histogram [3][256]int
+
+func newHistogram(data []byte) (h *histogram, err error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	h = &histogram{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			h.add(img, x, y)
+		}
+	}
+	return
+}
+
+func (h *histogram) add(img image.Image, x, y int) {
+	r, g, b, _ := img.At(x, y).RGBA()
+	h[0][r>>8]++
+	h[1][g>>8]++
+	h[2][b>>8]++
+}
+
+func (h *histogram) equals(other *histogram) bool {
+	return *h == *other
+}
+
+func (h *histogram) distance(other *histogram) (d int) {
+	for c := range h {
+		for i := range h[c] {
+			v := h[c][i] - other[c][i]
+			if v < 0 {
+				v = -v
+			}
+			d += v
+		}
+	}
+	return
+}
+
+// progress returns visual completeness of the frame (from 0 to 1)
+// relative to the initial and the final frames
+func (h *histogram) progress(start, final *histogram) float64 {
+	total := start.distance(final)
+	if total == 0 {
+		return 1
+	}
+
+	p := 1 - float64(h.distance(final))/float64(total)
+	if p < 0 {
+		return 0
+	}
+	return p
+}
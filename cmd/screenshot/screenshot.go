@@ -1,16 +1,17 @@
 package screenshot
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/raff/godet"
 
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
 	"github.com/iafan/hc/lib/util"
 )
 
@@ -18,15 +19,15 @@ import (
 type Command struct {
 	host lib.Host
 
-	blockedURLsParam string
-	blockedURLs      []string
-	url              string
-	stopEvent        string
-	initialWidth     int
-	initialHeight    int
-	maxWidth         int
-	maxHeight        int
-	wait             time.Duration
+	loader        loader.Loader
+	url           string
+	initialWidth  int
+	initialHeight int
+	maxWidth      int
+	maxHeight     int
+
+	filmstrip         bool
+	filmstripInterval time.Duration
 }
 
 // GetDescription implements Command.GetDescription
@@ -41,9 +42,15 @@ func (c *Command) ShowHelp() {
 	Load a specific page, wait for a specific page lifecycle event,
 	evaluate a JavaScript expression and print its result
 
+	In filmstrip mode (--filmstrip), capture viewport screenshots
+	at fixed intervals while the page loads and output a JSON report
+	with First Visual Change, Speed Index and Visually Complete times
+	(in milliseconds) along with the captured frames
+
 Usage:
 
 	hc eval [options] <URL> <JavaScript-expression>
+	hc screenshot [options] --urls-file <file> --output-file <template>
 	hc eval --help
 
 Available options:
@@ -57,83 +64,95 @@ Available options:
 func (c *Command) Init(host lib.Host) {
 	c.host = host
 
-	flag.StringVar(&c.stopEvent, "stop-event", "networkIdle", "Event to stop upon")
-	flag.DurationVar(&c.wait, "wait", 500*time.Millisecond, "Extra time to wait before making the screenshot")
 	flag.IntVar(&c.initialWidth, "initial-width", 1024, "Initial viewport width to render the page")
 	flag.IntVar(&c.initialHeight, "initial-height", 768, "Initial viewport height to render the page")
 	flag.IntVar(&c.maxWidth, "max-width", 0, "Maximum screenshot width (0 = no maximum)")
 	flag.IntVar(&c.maxHeight, "max-height", 0, "Maximum screenshot height (0 = no maximum)")
+	flag.BoolVar(&c.filmstrip, "filmstrip", false, "Capture a filmstrip of visual progress and output a JSON report")
+	flag.DurationVar(&c.filmstripInterval, "filmstrip-interval", 100*time.Millisecond, "Interval between filmstrip frames")
 
-	flag.StringVar(
-		&c.blockedURLsParam,
-		"blocked-urls",
-		"",
-		"Comma-separated list of file masks to block from loading",
-	)
+	c.loader.Init(host, "Extra time to wait before making the screenshot")
 }
 
 // Validate implements Command.Validate
 func (c *Command) Validate(args []string) {
-	if c.blockedURLsParam != "" {
-		c.blockedURLs = strings.Split(c.blockedURLsParam, ",")
+	c.loader.Validate()
+
+	// in batch mode, URLs are read from the file
+	nargs := 1
+	if c.host.IsBatch() {
+		nargs = 0
+	}
+
+	if len(args) != nargs {
+		util.StopOnUsageError(
+			"Usage: hc screenshot [options] <URL>\n" +
+				"       hc screenshot [options] --urls-file <file> --output-file <template>\n" +
+				"       hc screenshot --help\n",
+		)
 	}
 
-	if len(args) != 1 {
-		os.Stderr.WriteString("Usage: hc screenshot [options] <URL>\n")
-		os.Stderr.WriteString("       hc screenshot --help\n")
-		os.Exit(2)
+	if nargs == 1 {
+		c.url = args[0]
 	}
 
-	c.url = args[0]
+	if c.filmstrip && c.filmstripInterval <= 0 {
+		util.StopOnUsageError("Filmstrip interval must be positive\n")
+	}
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
 }
 
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
-	remote, err := c.host.ConnectToRemote()
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
 	if err != nil {
 		return
 	}
 	defer c.host.DisconnectFromRemote()
 
-	// block resource loading
-	if len(c.blockedURLs) > 0 {
-		err = remote.SetBlockedURLs(c.blockedURLs...)
-		if err != nil {
-			return
-		}
-	}
+	return c.RunURL(ctx, c.host, remote, c.url, outfile)
+}
 
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
 	err = util.SetDeviceMetricsOverride(remote, c.initialWidth, c.initialHeight, 1, false, false)
 	if err != nil {
 		return
 	}
 
-	remote.PageEvents(true)
-	if err != nil {
-		return
-	}
+	pageLoader := c.loader.Clone(host)
 
-	tabID, err := remote.Navigate(c.url)
-
-	status := make(chan bool, 2)
-	result := false
+	var recorder *filmstripRecorder
+	if c.filmstrip {
+		// apply the options first, so that frame times
+		// start at the navigation rather than at the setup
+		err = pageLoader.Prepare(remote, url)
+		if err != nil {
+			return
+		}
 
-	go func() {
-		time.Sleep(c.host.GetDeadline())
-		status <- false
-	}()
+		recorder = newFilmstripRecorder(remote, c.filmstripInterval)
+		recorder.Start()
 
-	remote.CallbackEvent("Page.lifecycleEvent", func(params godet.Params) {
-		if params["name"] == c.stopEvent && params["frameId"] == tabID {
-			time.Sleep(c.wait)
-			status <- true
-		}
-	})
+		// stop capturing if the page fails to load
+		defer recorder.Stop()
+	}
 
-	result = <-status
+	_, err = pageLoader.Load(ctx, remote, url)
+	if err != nil {
+		return
+	}
 
-	if !result {
-		return fmt.Errorf("Request timed out")
+	if recorder != nil {
+		err = recorder.Stop()
+		if err != nil {
+			return
+		}
+		return c.writeFilmstrip(host, recorder, url, outfile)
 	}
 
 	res, err := remote.EvaluateWrap("return document.documentElement.scrollWidth")
@@ -148,7 +167,7 @@ func (c *Command) Run(outfile *os.File) (err error) {
 	}
 	height := int(res.(float64))
 
-	if c.host.GetVerbose() {
+	if host.GetVerbose() {
 		log.Printf("Document size: %dx%d", width, height)
 	}
 
@@ -160,7 +179,7 @@ func (c *Command) Run(outfile *os.File) (err error) {
 		height = c.maxHeight
 	}
 
-	if c.host.GetVerbose() {
+	if host.GetVerbose() {
 		log.Printf("Screenshot size: %dx%d", width, height)
 	}
 
@@ -182,3 +201,21 @@ func (c *Command) Run(outfile *os.File) (err error) {
 
 	return
 }
+
+func (c *Command) writeFilmstrip(host lib.Host, recorder *filmstripRecorder, url string, outfile *os.File) (err error) {
+	report, err := recorder.Report(url)
+	if err != nil {
+		return
+	}
+
+	if host.GetVerbose() {
+		log.Printf(
+			"Captured %d frames; first visual change: %dms, speed index: %d, visually complete: %dms",
+			len(report.Frames), report.FirstVisualChange, report.SpeedIndex, report.VisuallyComplete,
+		)
+	}
+
+	enc := json.NewEncoder(outfile)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,173 @@
+package tables
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Table is a table extracted from the page; cells spanning several
+// rows or columns are repeated in each of them, and values of stacked
+// header rows are joined with " / "
+type Table struct {
+	Index   int        `json:"index"`
+	Caption string     `json:"caption"`
+	Headers []string   `json:"headers"`
+	Rows    [][]string `json:"rows"`
+}
+
+// writeCSV writes tables as CSV (with the header row, if any),
+// separated by empty lines
+func writeCSV(w io.Writer, tables []*Table) error {
+	for i, t := range tables {
+		if i > 0 {
+			_, err := io.WriteString(w, "\n")
+			if err != nil {
+				return err
+			}
+		}
+
+		cw := csv.NewWriter(w)
+		if len(t.Headers) > 0 {
+			cw.Write(t.Headers)
+		}
+		for _, row := range t.Rows {
+			cw.Write(row)
+		}
+		cw.Flush()
+
+		err := cw.Error()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSON writes tables as an indented JSON array
+func writeJSON(w io.Writer, tables []*Table) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tables)
+}
+
+// tablesScript returns a JSON-encoded object with an array of tables
+// matching the selector, or an error if the selector is invalid
+func tablesScript(selector string) string {
+	data, _ := json.Marshal(selector)
+	return fmt.Sprintf("(%s)(%s)", parseTablesScript, data)
+}
+
+const parseTablesScript = `function(selector) {
+	function text(el) {
+		return (el.innerText || el.textContent || '').replace(/\s+/g, ' ').trim();
+	}
+
+	function isHeader(row) {
+		if (row.parentNode.tagName === 'THEAD') {
+			return true;
+		}
+		return row.cells.length > 0 && Array.prototype.every.call(row.cells, function(cell) {
+			return cell.tagName === 'TH';
+		});
+	}
+
+	function parse(table) {
+		var rows = table.rows;
+		var grid = [];
+		var headerRows = 0;
+		var width = 0;
+		var sectionEnd = 0;
+
+		for (var r = 0; r < rows.length; r++) {
+			var row = rows[r];
+			grid[r] = grid[r] || [];
+
+			// rows of a section (thead, tbody, tfoot or rows outside of them)
+			// are listed together, and cells can't span across sections
+			if (r === sectionEnd) {
+				while (sectionEnd < rows.length && rows[sectionEnd].parentNode === row.parentNode) {
+					sectionEnd++;
+				}
+			}
+
+			// only leading rows are headers
+			if (headerRows === r && isHeader(row)) {
+				headerRows++;
+			}
+
+			var col = 0;
+			for (var i = 0; i < row.cells.length; i++) {
+				var cell = row.cells[i];
+
+				// skip columns occupied by cells spanning from rows above
+				while (grid[r][col] !== undefined) {
+					col++;
+				}
+
+				var colspan = Math.min(Math.max(cell.colSpan, 1), 1000);
+				var rowspan = cell.rowSpan === 0 ? sectionEnd - r : Math.min(Math.max(cell.rowSpan, 1), sectionEnd - r);
+				var value = text(cell);
+
+				for (var dr = 0; dr < rowspan; dr++) {
+					grid[r + dr] = grid[r + dr] || [];
+					for (var dc = 0; dc < colspan; dc++) {
+						grid[r + dr][col + dc] = value;
+					}
+				}
+				col += colspan;
+			}
+		}
+
+		grid.forEach(function(row) {
+			width = Math.max(width, row.length);
+		});
+
+		grid = grid.map(function(row) {
+			var cells = [];
+			for (var c = 0; c < width; c++) {
+				cells.push(row[c] === undefined ? '' : row[c]);
+			}
+			return cells;
+		});
+
+		var headers = [];
+		if (headerRows > 0) {
+			for (var c = 0; c < width; c++) {
+				var parts = [];
+				for (var h = 0; h < headerRows; h++) {
+					var v = grid[h][c];
+					if (v !== '' && parts[parts.length - 1] !== v) {
+						parts.push(v);
+					}
+				}
+				headers.push(parts.join(' / '));
+			}
+		}
+
+		return {
+			caption: table.caption ? text(table.caption) : '',
+			headers: headers,
+			rows: grid.slice(headerRows)
+		};
+	}
+
+	var elements;
+	try {
+		elements = document.querySelectorAll(selector);
+	} catch (e) {
+		return JSON.stringify({error: e.message});
+	}
+
+	var tables = Array.prototype.filter.call(elements, function(el) {
+		return el.tagName === 'TABLE';
+	});
+
+	return JSON.stringify({tables: tables.map(function(table, i) {
+		var t = parse(table);
+		t.index = i;
+		return t;
+	})});
+}`
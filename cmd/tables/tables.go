@@ -0,0 +1,206 @@
+package tables
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/loader"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Command implements 'tables' command
+type Command struct {
+	host lib.Host
+
+	loader       loader.Loader
+	url          string
+	selector     string
+	index        int
+	caption      string
+	outputFormat string
+}
+
+// GetDescription implements Command.GetDescription
+func (c *Command) GetDescription() string {
+	return "Load a specific page and extract its tables as CSV or JSON"
+}
+
+// ShowHelp implements Command.ShowHelp
+func (c *Command) ShowHelp() {
+	os.Stderr.WriteString(`Description:
+
+	Load a specific page, wait for a specific page lifecycle event,
+	and output its <table> elements as CSV or JSON.
+
+	Cells spanning several rows or columns (rowspan, colspan) are repeated
+	in each of them. Leading rows inside <thead>, or consisting of <th>
+	cells only, are headers; values of stacked header rows are joined
+	with " / ". In CSV, tables are separated by empty lines.
+
+Usage:
+
+	hc tables [options] <URL>
+	hc tables [options] --urls-file <file> --output-file <template>
+	hc tables --help
+
+Available options:
+
+`)
+
+	flag.PrintDefaults()
+}
+
+// Init implements Command.Init
+func (c *Command) Init(host lib.Host) {
+	c.host = host
+
+	flag.StringVar(&c.selector, "selector", "table", "CSS selector of <table> elements to extract")
+	flag.IntVar(&c.index, "index", -1, "Extract only the index-th (0-based) table out of the ones matching --selector")
+	flag.StringVar(&c.caption, "caption", "", "Extract only tables with the caption containing this text (case-insensitive)")
+	flag.StringVar(&c.outputFormat, "output-format", "csv", "Output format: 'csv' or 'json'")
+
+	c.loader.Init(host, "Extra time to wait before extracting tables")
+}
+
+// Validate implements Command.Validate
+func (c *Command) Validate(args []string) {
+	c.loader.Validate()
+
+	// in batch mode, URLs are read from the file
+	nargs := 1
+	if c.host.IsBatch() {
+		nargs = 0
+	}
+
+	if len(args) != nargs {
+		util.StopOnUsageError(
+			"Usage: hc tables [options] <URL>\n" +
+				"       hc tables [options] --urls-file <file> --output-file <template>\n" +
+				"       hc tables --help\n",
+		)
+	}
+
+	if nargs == 1 {
+		c.url = args[0]
+	}
+
+	switch c.outputFormat {
+	case "csv", "json":
+		break
+	default:
+		util.StopOnUsageError(fmt.Sprintf(
+			"Unknown output format: '%s'. Available formats: 'csv' or 'json'\n",
+			c.outputFormat,
+		))
+	}
+}
+
+// GetURL implements URLCommand.GetURL
+func (c *Command) GetURL() string {
+	return c.url
+}
+
+// Run implements Command.Run
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
+	remote, err := c.host.ConnectToRemote(ctx)
+	if err != nil {
+		return
+	}
+	defer c.host.DisconnectFromRemote()
+
+	return c.RunURL(ctx, c.host, remote, c.url, outfile)
+}
+
+// RunURL implements BatchCommand.RunURL
+func (c *Command) RunURL(ctx context.Context, host lib.Host, remote *godet.RemoteDebugger, url string, outfile *os.File) (err error) {
+	// the selector is checked by the browser, but before the page is loaded
+	err = c.checkSelector(ctx, remote)
+	if err != nil {
+		return
+	}
+
+	_, err = c.loader.Clone(host).Load(ctx, remote, url)
+	if err != nil {
+		return
+	}
+
+	res, err := util.Evaluate(ctx, &util.ExecutionContext{Session: remote}, tablesScript(c.selector))
+	if err != nil {
+		return
+	}
+
+	data, _ := res.(string)
+	var result struct {
+		Tables []*Table `json:"tables"`
+		Error  string   `json:"error"`
+	}
+	err = json.Unmarshal([]byte(data), &result)
+	if err != nil {
+		return fmt.Errorf("Failed to parse tables: %v", err)
+	}
+
+	if result.Error != "" {
+		return selectorError(c.selector, result.Error)
+	}
+
+	if len(result.Tables) == 0 {
+		return fmt.Errorf("No <table> elements match --selector '%s'", c.selector)
+	}
+
+	tables := c.filter(result.Tables)
+	if len(tables) == 0 {
+		return fmt.Errorf("None of %d tables matching --selector match --index or --caption", len(result.Tables))
+	}
+
+	if c.outputFormat == "json" {
+		return writeJSON(outfile, tables)
+	}
+	return writeCSV(outfile, tables)
+}
+
+// checkSelector returns a usage error if the browser
+// can't parse the selector
+func (c *Command) checkSelector(ctx context.Context, remote *godet.RemoteDebugger) error {
+	res, err := util.Evaluate(ctx, &util.ExecutionContext{Session: remote}, fmt.Sprintf(`(function() {
+		try {
+			document.createDocumentFragment().querySelector(%s);
+			return '';
+		} catch (e) {
+			return e.message;
+		}
+	})()`, util.JSString(c.selector)))
+	if err != nil {
+		return err
+	}
+
+	if message, _ := res.(string); message != "" {
+		return selectorError(c.selector, message)
+	}
+	return nil
+}
+
+func selectorError(selector string, message string) error {
+	return util.WithExitCode(util.ExitUsage, fmt.Errorf("Invalid --selector '%s': %s", selector, message))
+}
+
+// filter returns tables matching --index and --caption
+func (c *Command) filter(tables []*Table) (matched []*Table) {
+	caption := strings.ToLower(c.caption)
+	for _, t := range tables {
+		if c.index >= 0 && t.Index != c.index {
+			continue
+		}
+		if caption != "" && !strings.Contains(strings.ToLower(t.Caption), caption) {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	return
+}
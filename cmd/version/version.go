@@ -1,6 +1,7 @@
 package version
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -39,7 +40,7 @@ func (c *Command) Validate(args []string) {
 }
 
 // Run implements Command.Run
-func (c *Command) Run(outfile *os.File) (err error) {
+func (c *Command) Run(ctx context.Context, outfile *os.File) (err error) {
 	outfile.WriteString(fmt.Sprintf("hc version %s", lib.GetVersion()))
 	return
 }
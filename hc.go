@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/iafan/hc/cmd/cookies"
 	"github.com/iafan/hc/cmd/debug"
 	"github.com/iafan/hc/cmd/eval"
+	"github.com/iafan/hc/cmd/extract"
 	"github.com/iafan/hc/cmd/html"
+	"github.com/iafan/hc/cmd/profile"
 	"github.com/iafan/hc/cmd/resource"
+	"github.com/iafan/hc/cmd/run"
 	"github.com/iafan/hc/cmd/screenshot"
+	"github.com/iafan/hc/cmd/tables"
 	"github.com/iafan/hc/cmd/version"
 	"github.com/iafan/hc/host"
+	"github.com/iafan/hc/lib"
 	"github.com/iafan/hc/lib/util"
 )
 
@@ -22,17 +33,24 @@ func main() {
 	var args = os.Args[1:]
 
 	var host = host.New()
+	host.SetHandler("cookies", &cookies.Command{})
 	host.SetHandler("debug", &debug.Command{})
 	host.SetHandler("eval", &eval.Command{})
+	host.SetHandler("extract", &extract.Command{})
 	host.SetHandler("html", &html.Command{})
+	host.SetHandler("profile", &profile.Command{})
 	host.SetHandler("resource", &resource.Command{})
+	host.SetHandler("run", &run.Command{})
 	host.SetHandler("screenshot", &screenshot.Command{})
+	host.SetHandler("tables", &tables.Command{})
 	host.SetHandler("version", &version.Command{})
 
 	aliases := make(map[string]string)
+	aliases["c"] = "cookies"
 	aliases["d"] = "debug"
 	aliases["e"] = "eval"
 	aliases["h"] = "html"
+	aliases["p"] = "profile"
 	aliases["r"] = "resource"
 	aliases["s"] = "screenshot"
 	aliases["v"] = "version"
@@ -50,9 +68,15 @@ func main() {
 
 	handler := host.GetHandler(cmdName)
 	if handler == nil {
-		fmt.Printf("Unknown command: %s\n\n", args[0])
+		// flags of unknown commands are not parsed
+		lib.SetLogFormat(logFormatArg(args[1:]))
+		if lib.JSONLogEnabled() {
+			util.StopOnUsageError(fmt.Sprintf("Unknown command: %s", args[0]))
+		}
+
+		os.Stderr.WriteString(fmt.Sprintf("Unknown command: %s\n\n", args[0]))
 		host.ListCommands()
-		os.Exit(2)
+		os.Exit(util.ExitUsage)
 	}
 
 	// setup common flags to parse
@@ -61,11 +85,33 @@ func main() {
 	// init handler and setup its own flags to parse
 	handler.Init(host)
 
+	batchHandler, canBatch := handler.(lib.BatchCommand)
+	if canBatch {
+		host.InitBatch()
+	}
+
 	// init common flags
 	filename := ""
 	flag.StringVar(&filename, "output-file", "", "File to write output to; if not provided, will write to STDOUT")
 
-	flag.CommandLine.Parse(args[1:])
+	// flag errors are reported below in the requested log format
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	parseErr := flag.CommandLine.Parse(args[1:])
+	flag.CommandLine.SetOutput(os.Stderr)
+
+	err = lib.SetLogFormat(host.GetLogFormat())
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+
+	if parseErr == flag.ErrHelp {
+		handler.ShowHelp()
+		os.Exit(0)
+	}
+	if parseErr != nil {
+		util.StopOnUsageError(fmt.Sprintf("%v\nRun 'hc %s --help' for usage", parseErr, cmdName))
+	}
 
 	if host.GetShowHelp() {
 		handler.ShowHelp()
@@ -74,45 +120,150 @@ func main() {
 
 	// validate input data
 	handler.Validate(flag.Args())
+	host.GetOutputOptions().Validate()
+
+	var template *util.OutputTemplate
+	if filename != "" {
+		template, err = host.ParseOutputTemplate(filename)
+		if err != nil {
+			util.StopOnUsageError(err.Error())
+		}
+	}
+
+	batch := host.IsBatch()
+	if batch {
+		err = host.ValidateBatch(template)
+		if err != nil {
+			util.StopOnUsageError(err.Error())
+		}
+	}
 
 	file := os.Stdout
 
-	useFile := filename != ""
+	// in batch mode, the output file is a template of per-URL files
+	useFile := filename != "" && !batch
+
+	start := time.Now()
+
+	macros := &util.MacroValues{Time: start, Command: cmdName, Index: 1}
+	if urlHandler, ok := handler.(lib.URLCommand); ok {
+		macros.URL = urlHandler.GetURL()
+	}
+	host.SetMacroValues(macros)
+
+	// the output is written to a temporary file which replaces
+	// the output file only if the command succeeds (or reports
+	// its failure in the output)
+	var output *util.OutputFile
 
 	if useFile {
-		filename = util.ExpandMacros(filename)
+		filename = template.Expand(macros)
 		if host.GetVerbose() {
 			log.Printf("Opening [%s] for writing", filename)
 		}
 
-		file, err = os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0755)
+		output, err = host.GetOutputOptions().Create(filename)
 		if err != nil {
-			os.Stderr.WriteString(fmt.Sprintf("Failed to open [%s] file for writing: %v", filename, err))
-			os.Exit(4)
+			err = util.WithExitCode(util.ExitOutputFile, fmt.Errorf("Failed to open [%s] file for writing: %v", filename, err))
+			host.GetLogger().Result(err, util.ErrorClass(err), util.ExitOutputFile, 0)
+			util.StopOnError(err)
 		}
+		file = output.File
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		for range c {
-			os.Stderr.WriteString("\n")
-			host.RequestInterrupt()
+	// in batch mode, the deadline applies to each URL
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if batch {
+		ctx, cancel = context.WithCancel(context.Background())
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), host.GetDeadline())
+	}
+	defer cancel()
+
+	// cancel the command on SIGINT, SIGTERM or SIGHUP; the command
+	// then returns and cleanup below runs before exiting;
+	// the second signal removes the container and exits immediately
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// remove the container if the command or any of its goroutines panics
+	host.CleanupOnPanic(func() {
+		if output != nil {
+			output.Discard()
 		}
-	}()
+	})
 
 	// run the command
-	err = handler.Run(file)
+	interrupted, err := host.Execute(ctx, signals, func(ctx context.Context) error {
+		if batch {
+			return host.RunBatch(ctx, batchHandler, template, file)
+		}
+		return handler.Run(ctx, file)
+	})
 
 	var err2 error
 	if useFile {
-		err2 = file.Close()
+		// {STATUS} is only known after the page is loaded
+		macros.Status = host.GetResponseStatus()
+		filename = template.Expand(macros)
+		err2 = finishOutput(output, filename, err, host.GetVerbose())
+	}
+
+	logger := host.GetLogger()
+
+	if interrupted {
+		err = util.WithExitCode(util.ExitInterrupted, fmt.Errorf("Interrupted"))
+		logger.Result(err, util.ErrorClass(err), util.ExitInterrupted, time.Since(start))
+		os.Exit(util.ExitInterrupted)
 	}
 
 	// First, stop on command execution error,
-	// and only then check for file.Close() error
+	// and only then check for output file error
+	if err == nil {
+		err = err2
+	}
+
+	exitCode := 0
+	if err != nil {
+		exitCode = util.ExitCode(err)
+	}
+	logger.Result(err, util.ErrorClass(err), exitCode, time.Since(start))
+
 	util.StopOnError(err)
-	if useFile {
-		util.StopOnError(err2)
+}
+
+// finishOutput replaces the output file with the command output
+// if the command succeeds or reports its failure in the output,
+// and discards the output otherwise
+func finishOutput(output *util.OutputFile, filename string, cmdErr error, verbose bool) error {
+	if !util.KeepOutput(cmdErr) {
+		output.Discard()
+		return nil
+	}
+
+	if verbose {
+		log.Printf("Writing output to [%s]", filename)
+	}
+
+	err := output.Commit(filename)
+	if err != nil {
+		return util.WithExitCode(util.ExitOutputFile, fmt.Errorf("Failed to write [%s] file: %v", filename, err))
+	}
+	return nil
+}
+
+// logFormatArg returns the value of --log-format flag
+// found in the arguments, or the default one
+func logFormatArg(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == "log-format" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "log-format=") {
+			return strings.TrimPrefix(name, "log-format=")
+		}
 	}
+	return lib.LogFormatText
 }
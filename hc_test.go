@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iafan/hc/lib/util"
+)
+
+func TestFinishOutput(t *testing.T) {
+	// default output options
+	var options util.OutputOptions
+	options.Init()
+	options.Validate()
+
+	stepErr := util.WithExitCode(util.ExitTimeout, errors.New("Step #2 (click) failed"))
+
+	tests := []struct {
+		name    string
+		err     error
+		written bool
+	}{
+		{"success", nil, true},
+		{"failure", stepErr, false},
+		{"failure reported in the output", util.WithOutput(stepErr), true},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "result.json")
+		output, err := options.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output.WriteString(`{"success": false}`)
+
+		err = finishOutput(output, filename, test.err, false)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if test.written && string(data) != `{"success": false}` {
+			t.Errorf("%s: output is not written: %v", test.name, err)
+		}
+		if !test.written && !os.IsNotExist(err) {
+			t.Errorf("%s: output is written", test.name)
+		}
+
+		// only the output file is left in the directory
+		entries, _ := ioutil.ReadDir(filepath.Dir(filename))
+		for _, entry := range entries {
+			if entry.Name() != "result.json" {
+				t.Errorf("%s: temporary file is left behind", test.name)
+			}
+		}
+	}
+
+	// the exit code of the failed step is kept
+	if util.ExitCode(util.WithOutput(stepErr)) != util.ExitTimeout {
+		t.Errorf("exit code of the step error is lost")
+	}
+}
@@ -0,0 +1,504 @@
+package host
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/util"
+)
+
+// BatchResult is the result of processing a single URL in batch mode;
+// duration is in milliseconds
+type BatchResult struct {
+	Index      int    `json:"index"`
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	OutputFile string `json:"outputFile,omitempty"`
+	Duration   int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+	ExitCode   int    `json:"exitCode,omitempty"`
+}
+
+// BatchSummary is the summary of a batch written to the output
+type BatchSummary struct {
+	Total     int            `json:"total"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Skipped   int            `json:"skipped"`
+	Duration  int64          `json:"durationMs"`
+	Results   []*BatchResult `json:"results"`
+}
+
+// InitBatch specifies command-line flags to parse for commands
+// that support batch mode
+func (h *CommandHost) InitBatch() {
+	flag.StringVar(
+		&h.urlsFile,
+		"urls-file",
+		"",
+		"File with URLs to process in batch mode, one per line ('-' for STDIN); "+
+			"--output-file is then a template of per-URL output files, and a JSON summary is written to STDOUT",
+	)
+	flag.IntVar(&h.concurrency, "concurrency", 1, "Number of URLs to process concurrently in batch mode (each in its own tab)")
+	flag.IntVar(&h.containers, "containers", 1, "Number of containers to spread concurrently processed URLs across in batch mode")
+}
+
+// IsBatch implements Host.IsBatch
+func (h *CommandHost) IsBatch() bool {
+	return h.urlsFile != ""
+}
+
+// ValidateBatch validates batch mode settings
+// and the template of per-URL output files
+func (h *CommandHost) ValidateBatch(template *util.OutputTemplate) error {
+	if h.concurrency < 1 {
+		return fmt.Errorf("Concurrency must be positive")
+	}
+
+	if h.containers < 1 {
+		return fmt.Errorf("Number of containers must be positive")
+	}
+
+	if h.profileName != "" && h.containers > 1 {
+		return fmt.Errorf("Persistent profile can only be used with a single container")
+	}
+
+	if template == nil {
+		return fmt.Errorf("--output-file is required in batch mode")
+	}
+
+	if h.urlsFile == "-" {
+		err := util.ClaimStdin("--urls-file")
+		if err != nil {
+			return err
+		}
+	}
+
+	// make sure each URL is written to its own file
+	if !template.Uses("INDEX") && !template.Uses("URL_HASH") {
+		return fmt.Errorf("--output-file must contain {INDEX} or {URL_HASH} macro in batch mode")
+	}
+	return nil
+}
+
+// RunBatch processes URLs from the URLs file with the command using
+// a pool of workers, each loading URLs in its own tab, spread across
+// containers, and writes the summary to outfile; it fails if any URL
+// failed to process
+func (h *CommandHost) RunBatch(ctx context.Context, cmd lib.BatchCommand, template *util.OutputTemplate, outfile *os.File) (err error) {
+	urls, err := readURLs(h.urlsFile)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	summary := &BatchSummary{
+		Total:   len(urls),
+		Results: make([]*BatchResult, len(urls)),
+	}
+
+	if len(urls) > 0 {
+		err = h.runBatch(ctx, cmd, urls, template, summary.Results)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, r := range summary.Results {
+		switch r.Status {
+		case "ok":
+			summary.Succeeded++
+		case "skipped":
+			summary.Skipped++
+		default:
+			summary.Failed++
+		}
+	}
+	summary.Duration = int64(time.Since(start) / time.Millisecond)
+
+	enc := json.NewEncoder(outfile)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(summary)
+	if err != nil {
+		return
+	}
+
+	if ctx.Err() != nil {
+		return util.ContextError(ctx)
+	}
+
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d URLs failed", summary.Failed, summary.Total)
+	}
+	return
+}
+
+func (h *CommandHost) runBatch(
+	ctx context.Context, cmd lib.BatchCommand, urls []string,
+	template *util.OutputTemplate, results []*BatchResult,
+) (err error) {
+	concurrency := h.concurrency
+	if concurrency > len(urls) {
+		concurrency = len(urls)
+	}
+
+	containers := h.containers
+	if containers > concurrency {
+		containers = concurrency
+	}
+
+	hosts := make([]*CommandHost, containers)
+	for i := range hosts {
+		hosts[i] = h.newBatchHost()
+	}
+
+	h.mutex.Lock()
+	h.batchHosts = hosts
+	h.mutex.Unlock()
+
+	defer h.removeBatchContainers()
+
+	// containers are used until the batch is done, but their startup
+	// is bounded by the deadline, so the context is canceled by a timer
+	// stopped once they're started
+	containersCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := time.AfterFunc(h.deadline, cancel)
+
+	// start containers in parallel
+	errs := make(chan error, containers)
+	for _, bh := range hosts {
+		bh := bh
+		util.Go(func() {
+			_, err := bh.ConnectToNewDockerContainer(containersCtx)
+			errs <- err
+		})
+	}
+	for range hosts {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if !timer.Stop() && ctx.Err() == nil {
+		return util.Timeoutf("Containers failed to start within %v", h.deadline)
+	}
+	if err != nil {
+		return
+	}
+	ctx = containersCtx
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		bh := hosts[i%containers]
+		util.Go(func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = bh.processURL(ctx, cmd, idx, urls[idx], template)
+			}
+		})
+	}
+
+	for idx := range urls {
+		if ctx.Err() != nil {
+			results[idx] = &BatchResult{Index: idx + 1, URL: urls[idx], Status: "skipped"}
+			continue
+		}
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	return
+}
+
+// processURL processes a single URL in a new tab and writes
+// the output to the file defined by the template; if the command
+// panics, the URL is reported as failed and the batch continues
+func (h *CommandHost) processURL(
+	ctx context.Context, cmd lib.BatchCommand, idx int, url string, template *util.OutputTemplate,
+) (result *BatchResult) {
+	start := time.Now()
+
+	macros := &util.MacroValues{
+		Time:    start,
+		Command: h.cmdName,
+		URL:     url,
+		Index:   idx + 1,
+	}
+
+	result = &BatchResult{
+		Index:      idx + 1,
+		URL:        url,
+		Status:     "ok",
+		OutputFile: template.Expand(macros),
+	}
+
+	if h.verbose {
+		log.Printf("Processing #%d: %s", result.Index, url)
+	}
+
+	urlCtx, cancel := context.WithTimeout(ctx, h.deadline)
+	defer cancel()
+
+	uh := &urlHost{CommandHost: h, macros: macros, logger: h.logger.ForURL(result.Index, url)}
+
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Panic: %v", r)
+		}
+
+		result.Duration = int64(time.Since(start) / time.Millisecond)
+
+		if err != nil {
+			result.Status = "failed"
+			result.OutputFile = ""
+			result.Error = err.Error()
+			result.ErrorClass = util.ErrorClass(err)
+			result.ExitCode = util.ExitCode(err)
+
+			if h.verbose {
+				log.Printf("Failed to process #%d: %v", result.Index, err)
+			}
+		}
+	}()
+
+	err = h.withNewTab(urlCtx, func(remote *godet.RemoteDebugger) error {
+		file, err := h.output.Create(result.OutputFile)
+		if err != nil {
+			return util.WithExitCode(util.ExitOutputFile, err)
+		}
+
+		// Commit removes the temporary file too
+		committed := false
+		defer func() {
+			if !committed {
+				file.Discard()
+			}
+		}()
+
+		err = cmd.RunURL(urlCtx, uh, remote, url, file.File)
+		if err != nil {
+			return err
+		}
+
+		// {STATUS} is only known after the page is loaded
+		macros.Status = uh.getStatus()
+		result.OutputFile = template.Expand(macros)
+
+		committed = true
+		err = file.Commit(result.OutputFile)
+		if err != nil {
+			return util.WithExitCode(util.ExitOutputFile, err)
+		}
+		return nil
+	})
+	return
+}
+
+// withNewTab creates a new tab (in a new isolated browser context, unless
+// a persistent profile is used), calls fn with a connection to the tab,
+// and closes the tab afterwards; the connection is closed when
+// the context is done
+func (h *CommandHost) withNewTab(ctx context.Context, fn func(remote *godet.RemoteDebugger) error) (err error) {
+	h.mutex.Lock()
+	control := h.remote
+	address := h.debuggerAddress
+	h.mutex.Unlock()
+
+	if control == nil {
+		return fmt.Errorf("Not connected to a container")
+	}
+
+	params := godet.Params{"url": "about:blank"}
+
+	if h.profileName == "" {
+		var res map[string]interface{}
+		res, err = control.SendRequest("Target.createBrowserContext", godet.Params{})
+		if err != nil {
+			return
+		}
+		contextID, _ := res["browserContextId"].(string)
+		defer control.SendRequest("Target.disposeBrowserContext", godet.Params{"browserContextId": contextID})
+
+		params["browserContextId"] = contextID
+	}
+
+	res, err := control.SendRequest("Target.createTarget", params)
+	if err != nil {
+		return
+	}
+	targetID, _ := res["targetId"].(string)
+	defer control.SendRequest("Target.closeTarget", godet.Params{"targetId": targetID})
+
+	remote, err := godet.Connect(address, h.verboseDevTools)
+	if err != nil {
+		return util.WithErrorClass("devtools", err)
+	}
+
+	var once sync.Once
+	closeTab := func() {
+		once.Do(func() {
+			remote.Close()
+			util.RemoveEventListeners(remote)
+		})
+	}
+	defer closeTab()
+
+	done := make(chan struct{})
+	defer close(done)
+	util.Go(func() {
+		select {
+		case <-ctx.Done():
+			closeTab()
+		case <-done:
+		}
+	})
+
+	tabs, err := remote.TabList("page")
+	if err != nil {
+		return
+	}
+
+	for _, tab := range tabs {
+		if tab.ID == targetID {
+			err = remote.ActivateTab(tab)
+			if err != nil {
+				return
+			}
+			return fn(remote)
+		}
+	}
+	return fmt.Errorf("Tab %s not found", targetID)
+}
+
+// newBatchHost returns a host for an additional container
+// with the same settings
+func (h *CommandHost) newBatchHost() *CommandHost {
+	return &CommandHost{
+		verbose:         h.verbose,
+		verboseDevTools: h.verboseDevTools,
+		dockerImage:     h.dockerImage,
+		profileName:     h.profileName,
+		logFormat:       h.logFormat,
+		cmdName:         h.cmdName,
+		output:          h.output,
+		deadline:        h.deadline,
+		runtime:         h.runtime,
+	}
+}
+
+// removeBatchContainers removes containers started for the batch
+func (h *CommandHost) removeBatchContainers() {
+	h.mutex.Lock()
+	hosts := h.batchHosts
+	h.batchHosts = nil
+	h.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, bh := range hosts {
+		wg.Add(1)
+		bh := bh
+		util.Go(func() {
+			defer wg.Done()
+			bh.DisconnectAndRemoveDockerContainer()
+		})
+	}
+	wg.Wait()
+}
+
+// forceRemoveBatchContainers removes containers started for the batch
+// without a graceful shutdown
+func (h *CommandHost) forceRemoveBatchContainers() {
+	h.mutex.Lock()
+	hosts := h.batchHosts
+	h.mutex.Unlock()
+
+	for _, bh := range hosts {
+		err := bh.ForceRemoveDockerContainer()
+		if err != nil {
+			log.Printf("Error during removing the container: %v", err)
+		}
+	}
+}
+
+// readURLs reads non-empty lines that don't start with '#'
+// from the file ('-' means STDIN)
+func readURLs(filename string) (urls []string, err error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		var f *os.File
+		f, err = os.Open(filename)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// urlHost is the host passed to the command for a single URL;
+// it keeps the response status separately from other URLs
+// processed concurrently in the same container
+type urlHost struct {
+	*CommandHost
+	macros *util.MacroValues
+	logger *lib.Logger
+
+	statusMutex sync.Mutex
+	status      int
+}
+
+// GetLogger implements Host.GetLogger; events
+// of the logger include the URL and its index
+func (h *urlHost) GetLogger() *lib.Logger {
+	return h.logger
+}
+
+// SetResponseStatus implements Host.SetResponseStatus
+func (h *urlHost) SetResponseStatus(status int) {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+
+	h.status = status
+}
+
+func (h *urlHost) getStatus() int {
+	h.statusMutex.Lock()
+	defer h.statusMutex.Unlock()
+
+	return h.status
+}
+
+// ExpandFileName implements Host.ExpandFileName
+// for the URL being processed
+func (h *urlHost) ExpandFileName(template string) string {
+	v := *h.macros
+	v.Status = h.getStatus()
+	return h.expandFileName(template, &v)
+}
@@ -1,17 +1,24 @@
 package host
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib/profile"
+	"github.com/iafan/hc/lib/util"
 )
 
+// forceRemoveTimeout limits the time to remove the container on forced exit
+const forceRemoveTimeout = 10 * time.Second
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return err == nil
@@ -46,10 +53,18 @@ func getSeccompFilePath() (filename string) {
 }
 
 // ConnectToNewDockerContainer connects to a new docker container
-// and returns a connected instance of *godet.RemoteDebugger
-func (h *CommandHost) ConnectToNewDockerContainer() (remote *godet.RemoteDebugger, err error) {
-	if h.remote != nil {
-		return h.remote, nil
+// and returns a connected instance of *godet.RemoteDebugger;
+// the connection is closed when the context is done
+func (h *CommandHost) ConnectToNewDockerContainer(ctx context.Context) (remote *godet.RemoteDebugger, err error) {
+	h.mutex.Lock()
+	remote = h.remote
+	h.mutex.Unlock()
+	if remote != nil {
+		return
+	}
+
+	if ctx.Err() != nil {
+		return nil, util.ContextError(ctx)
 	}
 
 	seccompFile := getSeccompFilePath()
@@ -62,42 +77,60 @@ func (h *CommandHost) ConnectToNewDockerContainer() (remote *godet.RemoteDebugge
 		log.Printf("Creating a container from %s image", h.dockerImage)
 	}
 
-	h.setCanInterrupt(false)
-
-	cmd := exec.Command(
-		"docker", "run", "-d",
+	args := []string{
+		"run", "-d",
 		"-p", "127.0.0.1::9222",
 		"--security-opt", fmt.Sprintf("seccomp=%s", seccompFile),
-		h.dockerImage,
-	)
+	}
 
-	if h.verbose {
-		log.Printf("Command: %+v", cmd.Args)
+	if h.profileName != "" {
+		var profileArgs []string
+		profileArgs, err = h.lockProfile()
+		if err != nil {
+			return
+		}
+		args = append(args, profileArgs...)
+	} else {
+		args = append(args, h.dockerImage)
 	}
-	bytes, err := cmd.Output()
+
+	phase := h.logger.StartPhase("start")
+
+	// not bound to the context: the container must be created (and its ID
+	// recorded) even if interrupted, so that it can be removed afterwards
+	bytes, err := h.exec(context.Background(), args...)
 	if err != nil {
 		log.Printf("Error: %v", err)
+		err = util.WithErrorClass("docker", fmt.Errorf("Failed to create the container: %v", err))
 		return
 	}
 
-	h.containerName = strings.TrimSpace(string(bytes))
+	containerName := strings.TrimSpace(string(bytes))
 
-	if h.verbose {
-		log.Printf("Created container ID: %s", h.containerName)
-	}
+	h.mutex.Lock()
+	h.containerName = containerName
+	h.mutex.Unlock()
 
-	h.setCanInterrupt(true)
+	h.logger.SetContainerID(containerName)
 
-	cmd = exec.Command("docker", "port", h.containerName)
 	if h.verbose {
-		log.Printf("Command: %+v", cmd.Args)
+		log.Printf("Created container ID: %s", containerName)
 	}
-	bytes, err = cmd.Output()
+
+	if ctx.Err() != nil {
+		return nil, util.ContextError(ctx)
+	}
+
+	bytes, err = h.exec(ctx, "port", containerName)
 	if err != nil {
 		log.Printf("Error: %v", err)
+		err = util.WithErrorClass("docker", fmt.Errorf("Failed to get the container port: %v", err))
 		return
 	}
 
+	phase.End(map[string]interface{}{"image": h.dockerImage})
+	phase = h.logger.StartPhase("connect")
+
 	dbgHost := strings.TrimPrefix(strings.TrimSpace(string(bytes)), "9222/tcp -> ")
 
 	if h.verbose {
@@ -105,38 +138,108 @@ func (h *CommandHost) ConnectToNewDockerContainer() (remote *godet.RemoteDebugge
 	}
 
 	for i := 0; i < 5; i++ {
-		time.Sleep(100 * time.Millisecond)
+		err = util.Sleep(ctx, 100*time.Millisecond)
+		if err != nil {
+			return
+		}
 
 		remote, err = godet.Connect(
 			dbgHost, /*h.chromeHost*/
 			h.verboseDevTools,
 		)
 		if err == nil {
+			disconnected := make(chan struct{})
+
+			h.mutex.Lock()
 			h.remote = remote
+			h.debuggerAddress = dbgHost
+			h.disconnected = disconnected
+			h.mutex.Unlock()
+
+			util.Go(func() { h.closeRemoteOnDone(ctx, disconnected) })
+
+			phase.End(map[string]interface{}{"address": dbgHost})
 			return
 		}
 	}
+
+	err = util.WithErrorClass("devtools", fmt.Errorf("Failed to connect to %s: %v", dbgHost, err))
+	return
+}
+
+// closeRemoteOnDone closes the connection as soon as the context
+// is done, so that pending DevTools calls fail instead of blocking
+func (h *CommandHost) closeRemoteOnDone(ctx context.Context, disconnected chan struct{}) {
+	select {
+	case <-ctx.Done():
+		h.closeRemote()
+	case <-disconnected:
+	}
+}
+
+// closeRemote closes the connection to the headless Chrome instance, if any
+func (h *CommandHost) closeRemote() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.remote == nil {
+		return
+	}
+
+	if h.verbose {
+		log.Printf("Disconnecting")
+	}
+	err := h.remote.Close()
+	if err != nil {
+		log.Printf("Error during closing the connection: %v", err)
+	}
+	h.remote = nil
+
+	close(h.disconnected)
+}
+
+// CopyFileToDockerContainer copies a local file into the running
+// container and returns its path inside the container
+func (h *CommandHost) CopyFileToDockerContainer(filename string) (remoteFilename string, err error) {
+	h.mutex.Lock()
+	containerName := h.containerName
+	h.uploadCount++
+	remoteFilename = fmt.Sprintf("/tmp/hc-upload-%d-%s", h.uploadCount, filepath.Base(filename))
+	h.mutex.Unlock()
+
+	if containerName == "" {
+		err = fmt.Errorf("Not connected to a container")
+		return
+	}
+
+	_, err = h.exec(context.Background(), "cp", filename, containerName+":"+remoteFilename)
+	if err != nil {
+		err = fmt.Errorf("Failed to copy [%s] to the container: %v", filename, err)
+	}
 	return
 }
 
 // DisconnectAndRemoveDockerContainer dicsonnects from a headless Chrome
-// instance, and then stops and removes the temporary Docker container
+// instance, and then stops and removes the temporary Docker container;
+// it is safe to call it multiple times
 func (h *CommandHost) DisconnectAndRemoveDockerContainer() (err error) {
-	h.setCanInterrupt(false)
-	defer h.setCanInterrupt(true)
+	h.removeBatchContainers()
+	h.closeRemote()
 
-	if h.remote != nil {
-		if h.verbose {
-			log.Printf("Disconnecting")
-		}
-		err = h.remote.Close()
-		if err != nil {
-			log.Printf("Error during closing the connection: %v", err)
-		}
-		h.remote = nil
-	}
+	h.cleanupMutex.Lock()
+	defer h.cleanupMutex.Unlock()
+
+	h.mutex.Lock()
+	containerName := h.containerName
+	h.mutex.Unlock()
+
+	if containerName != "" {
+		phase := h.logger.TimePhase("cleanup")
+		defer phase.End(nil)
+
+		// not bound to the command context, which may already be done
+		ctx := context.Background()
 
-	if h.containerName != "" {
 		const maxAttempts = 3
 
 		attempt := 1
@@ -149,21 +252,19 @@ func (h *CommandHost) DisconnectAndRemoveDockerContainer() (err error) {
 				}
 			}
 
-			cmd := exec.Command("docker", "rm", "--force", "--volumes", h.containerName)
-			err = cmd.Run()
+			_, err = h.exec(ctx, "rm", "--force", "--volumes", containerName)
 			if err != nil {
 				log.Printf("Error during removing the container: %v", err)
 				return
 			}
 
-			cmd = exec.Command("docker", "container", "inspect", "--format", "1", h.containerName)
-			output, err := cmd.Output()
+			output, err := h.exec(ctx, "container", "inspect", "--format", "1", containerName)
 			if err != nil {
 				// assume the error we get indicates that the container wasn't found
 				break
 			}
 
-			if string(output) == "1" {
+			if strings.TrimSpace(string(output)) == "1" {
 				if attempt == maxAttempts {
 					log.Printf("Gave up after %d attempts", maxAttempts)
 					break
@@ -173,6 +274,98 @@ func (h *CommandHost) DisconnectAndRemoveDockerContainer() (err error) {
 				break
 			}
 		}
+
+		h.mutex.Lock()
+		h.containerName = ""
+		h.mutex.Unlock()
+	}
+
+	if h.profileLock != nil {
+		if h.verbose {
+			log.Printf("Releasing profile '%s'", h.profileName)
+		}
+		h.profileLock.Release()
+		h.profileLock = nil
+	}
+	return
+}
+
+// ForceRemoveDockerContainer removes the container without waiting
+// for the connection to close or for other cleanup to complete;
+// it is used when the process has to exit immediately
+func (h *CommandHost) ForceRemoveDockerContainer() (err error) {
+	h.mutex.Lock()
+	containerName := h.containerName
+	h.mutex.Unlock()
+
+	if containerName == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), forceRemoveTimeout)
+	defer cancel()
+
+	_, err = h.exec(ctx, "rm", "--force", "--volumes", containerName)
+	return
+}
+
+// exec runs a container runtime command
+func (h *CommandHost) exec(ctx context.Context, args ...string) ([]byte, error) {
+	if h.verbose {
+		log.Printf("Command: docker %s", strings.Join(args, " "))
+	}
+	return h.runtime.Exec(ctx, args...)
+}
+
+// lockProfile acquires a lock on the persistent profile (creating
+// the profile if needed) and returns `docker run` arguments to mount
+// the profile directory into the container and to make Chrome use it
+func (h *CommandHost) lockProfile() (args []string, err error) {
+	path, err := profile.Path(h.profileName)
+	if err != nil {
+		return
+	}
+
+	h.profileLock, err = profile.Lock(h.profileName)
+	if err != nil {
+		return
+	}
+
+	if !profile.Exists(h.profileName) {
+		if h.verbose {
+			log.Printf("Creating profile '%s'", h.profileName)
+		}
+		err = os.MkdirAll(path, 0700)
+		if err != nil {
+			return
+		}
 	}
+
+	err = profile.RemoveTransientFiles(h.profileName)
+	if err != nil {
+		return
+	}
+
+	if h.verbose {
+		log.Printf("Using profile '%s' from [%s]", h.profileName, path)
+	}
+
+	// run Chrome as the current user so that it can write
+	// to the mounted directory owned by this user; on Windows,
+	// there are no user IDs, and Docker Desktop maps file ownership
+	if runtime.GOOS != "windows" {
+		args = []string{"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())}
+	}
+	args = append(args,
+		"--env", "HOME=/tmp",
+		"--volume", fmt.Sprintf("%s:%s", path, profile.ContainerDir),
+		h.dockerImage,
+		// command-line arguments below replace image's default ones
+		"--headless",
+		"--disable-gpu",
+		"--remote-debugging-address=0.0.0.0",
+		"--remote-debugging-port=9222",
+		"--user-data-dir="+profile.ContainerDir,
+	)
 	return
 }
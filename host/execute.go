@@ -0,0 +1,75 @@
+package host
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/util"
+)
+
+// CleanupOnPanic makes sure the containers are removed if the command,
+// an event listener or a goroutine started via util.Go panics;
+// cleanup (if not nil) is called afterwards to release other resources
+func (h *CommandHost) CleanupOnPanic(cleanup func()) {
+	util.OnPanic(func() {
+		err := h.ForceDisconnectFromRemote()
+		if err != nil {
+			log.Printf("Error during removing the container: %v", err)
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+	})
+}
+
+// Execute runs the command and removes the containers once it returns.
+// The first signal received from signals cancels the command, which then
+// returns as usual; the second one removes the containers and exits
+// immediately. Returns true if the command was interrupted.
+func (h *CommandHost) Execute(
+	ctx context.Context, signals <-chan os.Signal, run func(ctx context.Context) error,
+) (interrupted bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	interruptedCh := make(chan struct{})
+
+	util.Go(func() {
+		sig := <-signals
+
+		if !lib.JSONLogEnabled() {
+			os.Stderr.WriteString("\n")
+		}
+		if h.verbose {
+			log.Printf("Interrupted (%v)", sig)
+		}
+		close(interruptedCh)
+		cancel()
+
+		sig = <-signals
+		log.Printf("Forcing exit (%v)", sig)
+		err := h.ForceDisconnectFromRemote()
+		if err != nil {
+			log.Printf("Error during removing the container: %v", err)
+		}
+		h.exit(util.ExitInterrupted)
+	})
+
+	// remove the containers if the command panics
+	defer util.HandlePanic()
+
+	err = run(ctx)
+
+	// make sure the container is removed even if the command
+	// failed before it could disconnect
+	h.DisconnectFromRemote()
+
+	select {
+	case <-interruptedCh:
+		interrupted = true
+	default:
+	}
+	return
+}
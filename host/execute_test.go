@@ -0,0 +1,129 @@
+package host
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/iafan/hc/lib/util"
+)
+
+func newTestHost() (*CommandHost, *recordingRuntime) {
+	runtime := newRecordingRuntime()
+	h := New()
+	h.SetRuntime(runtime)
+	h.exit = func(code int) {
+		panic("unexpected exit")
+	}
+	return h, runtime
+}
+
+func TestExecuteRemovesContainerOnSignal(t *testing.T) {
+	for _, sig := range []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP} {
+		h, runtime := newTestHost()
+		signals := make(chan os.Signal, 2)
+
+		interrupted, err := h.Execute(context.Background(), signals, func(ctx context.Context) error {
+			runtime.startContainer(h)
+			signals <- sig
+			<-ctx.Done()
+			return util.ContextError(ctx)
+		})
+
+		if !interrupted {
+			t.Errorf("%v: the command was not interrupted", sig)
+		}
+		if util.ExitCode(err) != util.ExitInterrupted {
+			t.Errorf("%v: expected exit code %d, got %d (%v)", sig, util.ExitInterrupted, util.ExitCode(err), err)
+		}
+		if runtime.running() != 0 {
+			t.Errorf("%v: the container was not removed", sig)
+		}
+	}
+}
+
+func TestExecuteRemovesContainerOnSecondSignal(t *testing.T) {
+	h, runtime := newTestHost()
+	signals := make(chan os.Signal, 2)
+
+	exitCode := make(chan int, 1)
+	h.exit = func(code int) {
+		exitCode <- code
+	}
+
+	// the command doesn't return when cancelled
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	go h.Execute(context.Background(), signals, func(ctx context.Context) error {
+		runtime.startContainer(h)
+		signals <- os.Interrupt
+		<-ctx.Done()
+		signals <- os.Interrupt
+		<-stuck
+		return nil
+	})
+
+	select {
+	case code := <-exitCode:
+		if code != util.ExitInterrupted {
+			t.Errorf("Expected exit code %d, got %d", util.ExitInterrupted, code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The process didn't exit on the second signal")
+	}
+
+	if runtime.running() != 0 {
+		t.Error("The container was not removed before exiting")
+	}
+}
+
+func TestExecuteRemovesContainerOnPanic(t *testing.T) {
+	h, runtime := newTestHost()
+	signals := make(chan os.Signal, 2)
+
+	cleanedUp := false
+	h.CleanupOnPanic(func() {
+		cleanedUp = true
+	})
+	defer util.OnPanic(nil)
+
+	func() {
+		defer func() {
+			if r := recover(); r != "command failed" {
+				t.Errorf("Expected the panic to be propagated, got %v", r)
+			}
+		}()
+
+		h.Execute(context.Background(), signals, func(ctx context.Context) error {
+			runtime.startContainer(h)
+			panic("command failed")
+		})
+	}()
+
+	if runtime.running() != 0 {
+		t.Error("The container was not removed")
+	}
+	if !cleanedUp {
+		t.Error("The cleanup function was not called")
+	}
+}
+
+func TestExecuteRemovesContainerOnSuccess(t *testing.T) {
+	h, runtime := newTestHost()
+	signals := make(chan os.Signal, 2)
+
+	interrupted, err := h.Execute(context.Background(), signals, func(ctx context.Context) error {
+		runtime.startContainer(h)
+		return nil
+	})
+
+	if interrupted || err != nil {
+		t.Errorf("Expected success, got interrupted=%v, err=%v", interrupted, err)
+	}
+	if runtime.running() != 0 {
+		t.Error("The container was not removed")
+	}
+}
@@ -1,38 +1,61 @@
 package host
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/raff/godet"
 
 	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/profile"
+	"github.com/iafan/hc/lib/util"
 )
 
 // CommandHost is a host for other commands
 type CommandHost struct {
-	showHelp           bool
-	verbose            bool
-	verboseDevTools    bool
-	canInterrupt       bool
-	interruptRequested bool
+	cmdName         string
+	showHelp        bool
+	verbose         bool
+	verboseDevTools bool
 	//chromeHost      string
 	dockerImage string
+	profileName string
+	logFormat   string
 	deadline    time.Duration
-	remote      *godet.RemoteDebugger
+	logger      lib.Logger
+	vars        util.StringList
+	output      util.OutputOptions
+	macros      util.MacroValues
+
+	urlsFile    string
+	concurrency int
+	containers  int
+	batchHosts  []*CommandHost
 	commands    map[string]lib.Command
 
-	containerName string
+	runtime      ContainerRuntime
+	exit         func(code int)
+	mutex        sync.Mutex
+	cleanupMutex sync.Mutex
+	remote       *godet.RemoteDebugger
+	disconnected chan struct{}
+
+	containerName   string
+	debuggerAddress string
+	profileLock     *profile.ProfileLock
+	uploadCount     int
+	responseStatus  int
 }
 
 // ConnectToRemote implements Host.ConnectToRemote
-func (h *CommandHost) ConnectToRemote() (remote *godet.RemoteDebugger, err error) {
-	return h.ConnectToNewDockerContainer()
+func (h *CommandHost) ConnectToRemote(ctx context.Context) (remote *godet.RemoteDebugger, err error) {
+	return h.ConnectToNewDockerContainer(ctx)
 }
 
 // DisconnectFromRemote implements Host.Disconnect
@@ -40,7 +63,26 @@ func (h *CommandHost) DisconnectFromRemote() (err error) {
 	return h.DisconnectAndRemoveDockerContainer()
 }
 
-// GetDeadline implements Host.GetDeadline
+// ForceDisconnectFromRemote removes the remote without a graceful
+// shutdown; it is used when the process has to exit immediately
+func (h *CommandHost) ForceDisconnectFromRemote() error {
+	h.forceRemoveBatchContainers()
+	return h.ForceRemoveDockerContainer()
+}
+
+// SetRuntime replaces the container runtime used to create
+// and remove containers (Docker CLI by default), e.g. with
+// a recording one in tests
+func (h *CommandHost) SetRuntime(runtime ContainerRuntime) {
+	h.runtime = runtime
+}
+
+// CopyFileToRemote implements Host.CopyFileToRemote
+func (h *CommandHost) CopyFileToRemote(filename string) (remoteFilename string, err error) {
+	return h.CopyFileToDockerContainer(filename)
+}
+
+// GetDeadline returns the maximum time for the command to complete
 func (h *CommandHost) GetDeadline() time.Duration {
 	return h.deadline
 }
@@ -50,40 +92,86 @@ func (h *CommandHost) GetVerbose() bool {
 	return h.verbose
 }
 
-// GetShowHelp implements Host.GetShowHelp
-func (h *CommandHost) GetShowHelp() bool {
-	return h.showHelp
+// GetLogger implements Host.GetLogger
+func (h *CommandHost) GetLogger() *lib.Logger {
+	return &h.logger
 }
 
-// RequestInterrupt implements Host.RequestInterrupt
-func (h *CommandHost) RequestInterrupt() {
-	if !h.canInterrupt {
-		h.interruptRequested = true
-		return
-	}
+// GetLogFormat returns the format of messages written to STDERR
+func (h *CommandHost) GetLogFormat() string {
+	return h.logFormat
+}
 
-	if h.verbose {
-		log.Printf("Interrupted")
-	}
+// SetResponseStatus implements Host.SetResponseStatus
+func (h *CommandHost) SetResponseStatus(status int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.responseStatus = status
+}
+
+// GetResponseStatus returns the HTTP status of the last loaded
+// main document (0 if unknown)
+func (h *CommandHost) GetResponseStatus() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.responseStatus
+}
 
-	err := h.DisconnectFromRemote()
+// GetOutputOptions returns options of writing output files
+func (h *CommandHost) GetOutputOptions() *util.OutputOptions {
+	return &h.output
+}
+
+// ParseOutputTemplate validates the output file name template
+// against built-in macros and custom ones defined with `--var`
+func (h *CommandHost) ParseOutputTemplate(template string) (*util.OutputTemplate, error) {
+	vars, err := util.ParseVars(h.vars)
 	if err != nil {
-		os.Stderr.WriteString(err.Error())
+		return nil, err
 	}
-	os.Exit(5)
+	return util.ParseOutputTemplate(template, vars)
+}
+
+// SetMacroValues sets values for expanding macros in output file names
+func (h *CommandHost) SetMacroValues(v *util.MacroValues) {
+	h.macros = *v
 }
 
-func (h *CommandHost) setCanInterrupt(canInterrupt bool) {
-	h.canInterrupt = canInterrupt
+// ValidateFileName implements Host.ValidateFileName
+func (h *CommandHost) ValidateFileName(template string) error {
+	t, err := h.ParseOutputTemplate(template)
+	if err != nil {
+		return err
+	}
 
-	if !canInterrupt {
-		h.interruptRequested = false
-		return
+	if h.IsBatch() && !t.Uses("INDEX") && !t.Uses("URL_HASH") {
+		return fmt.Errorf("File name must contain {INDEX} or {URL_HASH} macro in batch mode")
 	}
+	return nil
+}
+
+// ExpandFileName implements Host.ExpandFileName
+func (h *CommandHost) ExpandFileName(template string) string {
+	v := h.macros
+	v.Status = h.GetResponseStatus()
+	return h.expandFileName(template, &v)
+}
 
-	if h.interruptRequested {
-		h.RequestInterrupt() // exit
+// expandFileName expands macros in the file name validated
+// by ValidateFileName
+func (h *CommandHost) expandFileName(template string, v *util.MacroValues) string {
+	t, err := h.ParseOutputTemplate(template)
+	if err != nil {
+		return template
 	}
+	return t.Expand(v)
+}
+
+// GetShowHelp implements Host.GetShowHelp
+func (h *CommandHost) GetShowHelp() bool {
+	return h.showHelp
 }
 
 // ListCommands renders a formatted list of registered commands
@@ -128,18 +216,30 @@ func (h *CommandHost) GetHandler(cmdName string) lib.Command {
 
 // Init specifies common command-line flags to parse
 func (h *CommandHost) Init(cmdName string) {
+	h.cmdName = cmdName
+
 	flag.BoolVar(&h.showHelp, "help", false, "Show help")
 	flag.BoolVar(&h.verbose, "verbose", cmdName == "debug", "Show verbose messages")
 	flag.BoolVar(&h.verboseDevTools, "verbose-devtools", cmdName == "debug", "Show verbose DevTools protocol messages")
 	//flag.StringVar(&h.chromeHost, "host", /*"localhost:9222"*/, "Headless Chrome hostname to connect to")
 	flag.StringVar(&h.dockerImage, "docker-image", "justinribeiro/chrome-headless", "Docker image to use to spin up a temporary container")
-	flag.DurationVar(&h.deadline, "deadline", 30*time.Second, "Deadline")
+	flag.StringVar(&h.logFormat, "log-format", lib.LogFormatText, "Format of log messages and errors written to STDERR: 'text' or 'json'")
+	flag.DurationVar(&h.deadline, "deadline", 30*time.Second, "Maximum time for the command to complete")
+	h.output.Init()
+	flag.Var(&h.vars, "var", "Custom macro for output file names in 'key=value' format, used as {key} (can be repeated)")
+	flag.StringVar(
+		&h.profileName,
+		"profile",
+		"",
+		"Name of a persistent browser profile to use (see 'hc profile --help'); created automatically if missing",
+	)
 }
 
 // New returns an initialized command host instance
 func New() *CommandHost {
 	return &CommandHost{
-		commands:     make(map[string]lib.Command),
-		canInterrupt: true,
+		commands: make(map[string]lib.Command),
+		runtime:  DockerRuntime{},
+		exit:     os.Exit,
 	}
 }
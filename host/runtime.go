@@ -0,0 +1,37 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ContainerRuntime runs container management commands (`run`, `port`,
+// `cp`, `rm`, `container inspect`) with Docker CLI semantics; it is
+// an interface so that the container lifecycle can be driven by
+// a different runtime or by a fake one that records created containers
+type ContainerRuntime interface {
+	// Exec runs the command with the given arguments and returns
+	// its standard output; on failure, the error includes
+	// the command's standard error
+	Exec(ctx context.Context, args ...string) (output []byte, err error)
+}
+
+// DockerRuntime runs commands via the `docker` CLI
+type DockerRuntime struct{}
+
+// Exec implements ContainerRuntime.Exec
+func (r DockerRuntime) Exec(ctx context.Context, args ...string) (output []byte, err error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stderr = &stderr
+
+	output, err = cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// recordingRuntime is a fake container runtime that records
+// executed commands and tracks running containers
+type recordingRuntime struct {
+	mutex      sync.Mutex
+	commands   []string
+	containers map[string]bool
+	lastID     int
+}
+
+func newRecordingRuntime() *recordingRuntime {
+	return &recordingRuntime{containers: make(map[string]bool)}
+}
+
+// Exec implements ContainerRuntime.Exec
+func (r *recordingRuntime) Exec(ctx context.Context, args ...string) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.commands = append(r.commands, strings.Join(args, " "))
+
+	switch args[0] {
+	case "run":
+		r.lastID++
+		id := fmt.Sprintf("container-%d", r.lastID)
+		r.containers[id] = true
+		return []byte(id + "\n"), nil
+	case "rm":
+		delete(r.containers, args[len(args)-1])
+		return nil, nil
+	case "container":
+		// `container inspect` fails for removed containers
+		if !r.containers[args[len(args)-1]] {
+			return nil, fmt.Errorf("No such container: %s", args[len(args)-1])
+		}
+		return []byte("1\n"), nil
+	}
+	return nil, fmt.Errorf("Unexpected command: %s", strings.Join(args, " "))
+}
+
+// running returns the number of containers that haven't been removed
+func (r *recordingRuntime) running() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.containers)
+}
+
+// startContainer creates a container as ConnectToNewDockerContainer
+// does before connecting to it
+func (r *recordingRuntime) startContainer(h *CommandHost) {
+	output, _ := h.exec(context.Background(), "run", "-d", h.dockerImage)
+	h.mutex.Lock()
+	h.containerName = strings.TrimSpace(string(output))
+	h.mutex.Unlock()
+}
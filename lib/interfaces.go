@@ -1,26 +1,52 @@
 package lib
 
 import (
+	"context"
 	"os"
-	"time"
 
 	"github.com/raff/godet"
 )
 
 // Host defines an interface for command host
 type Host interface {
-	ConnectToRemote() (*godet.RemoteDebugger, error)
+	ConnectToRemote(ctx context.Context) (*godet.RemoteDebugger, error)
 	DisconnectFromRemote() error
-	GetDeadline() time.Duration
+	CopyFileToRemote(filename string) (string, error)
 	GetVerbose() bool
-	RequestInterrupt()
+	GetLogger() *Logger
+	IsBatch() bool
+	SetResponseStatus(status int)
+	// ValidateFileName validates macros (see `--output-file`) in the name
+	// of an additional output file; in batch mode, the name must be
+	// unique for each URL
+	ValidateFileName(template string) error
+	// ExpandFileName expands macros in the name of an additional
+	// output file for the page being processed
+	ExpandFileName(template string) string
 }
 
-// Command defines an interface for pluggable commands
+// Command defines an interface for pluggable commands;
+// the context passed to Run carries the deadline and is cancelled
+// when the command is interrupted
 type Command interface {
 	GetDescription() string
 	ShowHelp()
 	Init(host Host)
 	Validate(args []string)
-	Run(outfile *os.File) error
+	Run(ctx context.Context, outfile *os.File) error
+}
+
+// URLCommand is implemented by commands that load a page given
+// on the command line; the URL is used to expand output file name macros
+type URLCommand interface {
+	GetURL() string
+}
+
+// BatchCommand is implemented by page-loading commands that can
+// process many URLs in one invocation (see `--urls-file`); in batch mode,
+// the URL argument is omitted, and instead of Run, RunURL is called
+// for each URL with a connection to a fresh browser tab of the given host
+type BatchCommand interface {
+	Command
+	RunURL(ctx context.Context, host Host, remote *godet.RemoteDebugger, url string, outfile *os.File) error
 }
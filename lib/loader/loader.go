@@ -0,0 +1,601 @@
+// Package loader implements page loading shared by all page-loading
+// commands: applying emulation, request, blocking and preload script
+// options before navigation, navigating, tracking the main document
+// response and redirects, and waiting for stop conditions until
+// the context is done.
+//
+// A command embeds a Loader, calls Init in its own Init (to register
+// common flags), Validate in its own Validate, and Load in its Run:
+//
+//	remote, err := c.host.ConnectToRemote(ctx)
+//	if err != nil {
+//		return
+//	}
+//	defer c.host.DisconnectFromRemote()
+//
+//	page, err := c.loader.Load(ctx, remote, c.url)
+//	if err != nil {
+//		return
+//	}
+package loader
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+	"github.com/iafan/hc/lib/util"
+)
+
+// Loader loads pages with consistent semantics and flags
+type Loader struct {
+	host lib.Host
+
+	blockedURLsParam  string
+	blockedURLs       []string
+	failOnStatusParam string
+	failOnStatus      []statusRange
+	stopEvent         string
+	wait              time.Duration
+
+	media     util.MediaOptions
+	emulation util.EmulationOptions
+	request   util.RequestOptions
+	preload   util.PreloadOptions
+	waitFor   *util.WaitOptions
+	settle    *util.SettleOptions
+	input     util.InputOptions
+
+	fullLoad bool
+	prepared bool
+}
+
+// Redirect describes a redirect of the main document
+type Redirect struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// Response describes the main document response
+type Response struct {
+	URL        string `json:"url"`
+	Status     int    `json:"status"`
+	StatusText string `json:"statusText"`
+	MimeType   string `json:"mimeType"`
+}
+
+// Page is a page being loaded
+type Page struct {
+	URL      string
+	FrameID  string
+	LoaderID string
+
+	start time.Time
+
+	mutex     sync.Mutex
+	responses map[string]*Response
+	redirects map[string][]Redirect
+	failures  map[string]string
+	lifecycle chan godet.Params
+	failed    chan struct{}
+
+	// functions removing event listeners
+	listeners []func()
+}
+
+// statusRange is an inclusive range of HTTP status codes
+type statusRange struct {
+	from int
+	to   int
+}
+
+// navigationErrors maps network error prefixes reported by Chrome
+// to process exit codes; the first matching prefix wins
+var navigationErrors = []struct {
+	prefix string
+	code   int
+}{
+	{"net::ERR_NAME_NOT_RESOLVED", util.ExitDNSError},
+	{"net::ERR_NAME_RESOLUTION_FAILED", util.ExitDNSError},
+	{"net::ERR_CONNECTION_REFUSED", util.ExitConnectionRefused},
+	{"net::ERR_CERT_", util.ExitTLSError},
+	{"net::ERR_SSL_", util.ExitTLSError},
+	{"net::ERR_BAD_SSL_CLIENT_AUTH_CERT", util.ExitTLSError},
+	{"net::ERR_TIMED_OUT", util.ExitTimeout},
+	{"net::ERR_CONNECTION_TIMED_OUT", util.ExitTimeout},
+}
+
+// Init specifies command-line flags to parse for loading the page
+// and waiting for it to be ready; waitUsage describes the `--wait` flag
+func (l *Loader) Init(host lib.Host, waitUsage string) {
+	l.InitNavigation(host)
+	l.fullLoad = true
+
+	flag.StringVar(&l.stopEvent, "stop-event", "networkIdle", "Event to stop upon")
+	flag.DurationVar(&l.wait, "wait", 500*time.Millisecond, waitUsage)
+
+	l.waitFor.Init()
+	l.settle.Init()
+	l.input.Init()
+}
+
+// InitNavigation specifies command-line flags to parse for navigation
+// only, for commands that define their own completion conditions
+// and only use Navigate
+func (l *Loader) InitNavigation(host lib.Host) {
+	l.host = host
+	l.waitFor = &util.WaitOptions{}
+	l.settle = &util.SettleOptions{}
+
+	flag.StringVar(
+		&l.blockedURLsParam,
+		"blocked-urls",
+		"",
+		"Comma-separated list of file masks to block from loading",
+	)
+
+	flag.StringVar(
+		&l.failOnStatusParam,
+		"fail-on-status",
+		"",
+		"Comma-separated list of main document HTTP status codes or classes to fail on (e.g. '4xx,5xx' or '404')",
+	)
+
+	l.media.Init()
+	l.emulation.Init()
+	l.request.Init()
+	l.preload.Init()
+}
+
+// Clone returns a copy of the validated loader with a fresh state
+// that uses the given host, so that several pages can be loaded
+// concurrently (e.g. in different containers)
+func (l *Loader) Clone(host lib.Host) *Loader {
+	return &Loader{
+		host:         host,
+		blockedURLs:  l.blockedURLs,
+		failOnStatus: l.failOnStatus,
+		stopEvent:    l.stopEvent,
+		wait:         l.wait,
+		media:        l.media,
+		emulation:    l.emulation,
+		request:      l.request,
+		preload:      l.preload,
+		waitFor:      l.waitFor.Clone(),
+		settle:       l.settle.Clone(),
+		input:        l.input,
+		fullLoad:     l.fullLoad,
+	}
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (l *Loader) Validate() {
+	if l.blockedURLsParam != "" {
+		l.blockedURLs = strings.Split(l.blockedURLsParam, ",")
+	}
+
+	err := l.parseFailOnStatus()
+	if err != nil {
+		util.StopOnUsageError(err.Error())
+	}
+
+	l.media.Validate()
+	l.emulation.Validate()
+	l.request.Validate()
+	l.preload.Validate()
+
+	if l.fullLoad {
+		l.waitFor.Validate()
+		l.settle.Validate()
+		l.input.Validate()
+	}
+}
+
+// Load navigates to the URL and waits for the page to be ready:
+// for the stop event, wait conditions, and the page to settle (if requested),
+// then waits for the extra time and performs input actions; the returned
+// page is closed (its response and redirects remain available)
+func (l *Loader) Load(ctx context.Context, remote *godet.RemoteDebugger, url string) (page *Page, err error) {
+	page, err = l.Navigate(ctx, remote, url)
+	if err != nil {
+		return
+	}
+	defer page.Close()
+
+	err = l.Wait(ctx, remote, page)
+	return
+}
+
+// Navigate applies the options and navigates to the URL
+// without waiting for the page to load; the page must be closed
+// when it's loaded
+func (l *Loader) Navigate(ctx context.Context, remote *godet.RemoteDebugger, url string) (page *Page, err error) {
+	p := &Page{
+		URL:       url,
+		responses: make(map[string]*Response),
+		redirects: make(map[string][]Redirect),
+		failures:  make(map[string]string),
+		lifecycle: make(chan godet.Params, 1000),
+		failed:    make(chan struct{}, 1),
+	}
+
+	phase := l.host.GetLogger().StartPhase("navigate")
+
+	if !l.prepared {
+		err = l.Prepare(remote, url)
+		if err != nil {
+			return
+		}
+	}
+
+	p.subscribe(remote, l.host.GetVerbose())
+	defer func() {
+		if err != nil {
+			p.Close()
+		}
+	}()
+
+	if l.host.GetVerbose() {
+		log.Printf("Navigating to %s", url)
+	}
+
+	p.start = time.Now()
+
+	res, err := util.SendRequest(ctx, remote, "Page.navigate", godet.Params{"url": url})
+	if err != nil {
+		return nil, err
+	}
+
+	if errorText, _ := res["errorText"].(string); errorText != "" {
+		return nil, NavigationError(url, errorText)
+	}
+
+	p.FrameID, _ = res["frameId"].(string)
+	p.LoaderID, _ = res["loaderId"].(string)
+
+	phase.End(map[string]interface{}{"url": url})
+	return p, nil
+}
+
+// Prepare applies options that must be set before navigation
+// and enables page and network events; it is called by Navigate
+// and only needs to be called explicitly by commands that navigate
+// on their own. Cookies without domain are bound to the provided URL.
+func (l *Loader) Prepare(remote *godet.RemoteDebugger, url string) (err error) {
+	verbose := l.host.GetVerbose()
+	l.prepared = true
+
+	// override user agent, locale, timezone and geolocation
+	err = l.emulation.Apply(remote)
+	if err != nil {
+		return
+	}
+
+	// block resource loading
+	if len(l.blockedURLs) > 0 {
+		err = remote.SetBlockedURLs(l.blockedURLs...)
+		if err != nil {
+			return
+		}
+	}
+
+	// emulate CSS media type and features
+	err = l.media.Apply(remote)
+	if err != nil {
+		return
+	}
+
+	// set extra headers, cookies and HTTP authentication
+	err = l.request.Apply(remote, url, verbose)
+	if err != nil {
+		return
+	}
+
+	// run user scripts before page scripts
+	err = l.preload.Apply(remote)
+	if err != nil {
+		return
+	}
+
+	// track network activity for wait conditions and settling
+	err = l.waitFor.Subscribe(remote)
+	if err != nil {
+		return
+	}
+
+	err = l.settle.Subscribe(remote)
+	if err != nil {
+		return
+	}
+
+	err = remote.PageEvents(true)
+	if err != nil {
+		return
+	}
+	return remote.NetworkEvents(true)
+}
+
+// Wait waits for the page to be ready; it fails if the main document
+// failed to load or its status matches `--fail-on-status`
+func (l *Loader) Wait(ctx context.Context, remote *godet.RemoteDebugger, page *Page) (err error) {
+	logger := l.host.GetLogger()
+	phase := logger.StartPhase("load")
+
+	err = page.WaitForEvent(ctx, l.stopEvent)
+	if err != nil {
+		return
+	}
+
+	err = l.CheckResponse(page)
+	if err != nil {
+		return
+	}
+
+	// wait for page conditions
+	err = l.waitFor.Wait(ctx, remote)
+	if err != nil {
+		return
+	}
+
+	// wait for the page to settle
+	err = l.settle.Settle(ctx, remote)
+	if err != nil {
+		return
+	}
+
+	err = util.Sleep(ctx, l.wait)
+	if err != nil {
+		return
+	}
+
+	phase.End(page.details())
+
+	// perform user input actions
+	if l.input.Enabled() {
+		phase = logger.StartPhase("input")
+		err = l.input.Apply(ctx, remote, l.host)
+		if err != nil {
+			return
+		}
+		phase.End(nil)
+	}
+
+	// from now on, the command captures its output
+	logger.StartPhase("capture")
+	return
+}
+
+// CheckResponse returns an error if the main document failed to load
+// or if its HTTP status matches `--fail-on-status`
+func (l *Loader) CheckResponse(page *Page) error {
+	err := page.Err()
+	if err != nil {
+		return err
+	}
+
+	resp := page.Response()
+	if resp == nil {
+		return nil
+	}
+
+	// used for {STATUS} macro in output file names
+	l.host.SetResponseStatus(resp.Status)
+
+	for _, r := range l.failOnStatus {
+		if resp.Status >= r.from && resp.Status <= r.to {
+			return util.WithExitCode(util.ExitHTTPError, fmt.Errorf(
+				"%s returned HTTP status %d %s", resp.URL, resp.Status, resp.StatusText,
+			))
+		}
+	}
+	return nil
+}
+
+// parseFailOnStatus parses comma-separated status codes ('404')
+// and classes ('4xx')
+func (l *Loader) parseFailOnStatus() error {
+	if l.failOnStatusParam == "" {
+		return nil
+	}
+
+	for _, s := range strings.Split(l.failOnStatusParam, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+
+		if len(s) == 3 && s[1:] == "xx" && s[0] >= '1' && s[0] <= '5' {
+			from := int(s[0]-'0') * 100
+			l.failOnStatus = append(l.failOnStatus, statusRange{from, from + 99})
+			continue
+		}
+
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("Invalid --fail-on-status value: '%s'", s)
+		}
+		l.failOnStatus = append(l.failOnStatus, statusRange{code, code})
+	}
+	return nil
+}
+
+// NavigationError returns an error for a failed navigation
+// with an exit code depending on the failure type
+// (e.g. 'net::ERR_NAME_NOT_RESOLVED' results in ExitDNSError)
+func NavigationError(url string, errorText string) error {
+	err := fmt.Errorf("Navigation to %s failed: %s", url, errorText)
+	for _, e := range navigationErrors {
+		if strings.HasPrefix(errorText, e.prefix) {
+			return util.WithExitCode(e.code, err)
+		}
+	}
+	return err
+}
+
+// Close stops tracking events of the page; its response
+// and redirects remain available
+func (p *Page) Close() {
+	for _, remove := range p.listeners {
+		remove()
+	}
+	p.listeners = nil
+}
+
+func (p *Page) listen(remote *godet.RemoteDebugger, method string, cb godet.EventCallback) {
+	p.listeners = append(p.listeners, util.AddEventListener(remote, method, cb))
+}
+
+func (p *Page) subscribe(remote *godet.RemoteDebugger, verbose bool) {
+	p.listen(remote, "Page.lifecycleEvent", func(params godet.Params) {
+		select {
+		case p.lifecycle <- params:
+		default:
+		}
+	})
+
+	p.listen(remote, "Network.requestWillBeSent", func(params godet.Params) {
+		if params["type"] != "Document" || params["redirectResponse"] == nil {
+			return
+		}
+
+		requestID, _ := params["requestId"].(string)
+		resp := newResponse(params["redirectResponse"])
+
+		if verbose {
+			log.Printf("Redirected from %s (%d)", resp.URL, resp.Status)
+		}
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.redirects[requestID] = append(p.redirects[requestID], Redirect{
+			URL:    resp.URL,
+			Status: resp.Status,
+		})
+	})
+
+	p.listen(remote, "Network.responseReceived", func(params godet.Params) {
+		if params["type"] != "Document" {
+			return
+		}
+
+		requestID, _ := params["requestId"].(string)
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.responses[requestID] = newResponse(params["response"])
+	})
+
+	p.listen(remote, "Network.loadingFailed", func(params godet.Params) {
+		// skip documents whose loading was superseded by another navigation
+		if canceled, _ := params["canceled"].(bool); canceled || params["type"] != "Document" {
+			return
+		}
+
+		requestID, _ := params["requestId"].(string)
+		errorText, _ := params["errorText"].(string)
+
+		if verbose {
+			log.Printf("Document loading failed: %s", errorText)
+		}
+
+		p.mutex.Lock()
+		p.failures[requestID] = errorText
+		p.mutex.Unlock()
+
+		select {
+		case p.failed <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func newResponse(value interface{}) *Response {
+	resp, _ := value.(map[string]interface{})
+	status, _ := resp["status"].(float64)
+	r := &Response{Status: int(status)}
+	r.URL, _ = resp["url"].(string)
+	r.StatusText, _ = resp["statusText"].(string)
+	r.MimeType, _ = resp["mimeType"].(string)
+	return r
+}
+
+// Elapsed returns the time elapsed since the navigation start
+func (p *Page) Elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+// WaitForEvent waits for a page lifecycle event of the main frame
+// (e.g. 'load' or 'networkIdle') until the context is done;
+// it returns early if loading of the main document fails
+func (p *Page) WaitForEvent(ctx context.Context, name string) error {
+	for {
+		select {
+		case params := <-p.lifecycle:
+			if params["name"] != name || params["frameId"] != p.FrameID {
+				continue
+			}
+			// skip events of the document loaded before the navigation
+			if loaderID, _ := params["loaderId"].(string); loaderID != "" && loaderID != p.LoaderID {
+				continue
+			}
+			return nil
+		case <-p.failed:
+			err := p.Err()
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return util.ContextError(ctx)
+		}
+	}
+}
+
+// Response returns the main document response,
+// or nil if it wasn't received (yet)
+func (p *Page) Response() *Response {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.responses[p.LoaderID]
+}
+
+// details returns the page details reported in structured logs
+func (p *Page) details() map[string]interface{} {
+	details := map[string]interface{}{"url": p.URL}
+	if resp := p.Response(); resp != nil {
+		details["responseUrl"] = resp.URL
+		details["status"] = resp.Status
+	}
+	if redirects := p.Redirects(); len(redirects) > 0 {
+		details["redirects"] = redirects
+	}
+	return details
+}
+
+// Err returns an error if loading of the main document failed
+// (e.g. due to a network or certificate error)
+func (p *Page) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	errorText, ok := p.failures[p.LoaderID]
+	if !ok {
+		return nil
+	}
+	return NavigationError(p.URL, errorText)
+}
+
+// Redirects returns the redirect chain of the main document
+func (p *Page) Redirects() []Redirect {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.redirects[p.LoaderID]
+}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// stderr serializes writes of log entries to STDERR
+var stderr = struct {
+	sync.Mutex
+	w io.Writer
+}{w: os.Stderr}
+
+var jsonLogEnabled bool
+
+// LogEntry is a structured log event written to STDERR
+// in the JSON log format, one per line
+type LogEntry struct {
+	Time        string                 `json:"time"`
+	Level       string                 `json:"level"`
+	Phase       string                 `json:"phase,omitempty"`
+	Message     string                 `json:"message,omitempty"`
+	ContainerID string                 `json:"containerId,omitempty"`
+	URL         string                 `json:"url,omitempty"`
+	Index       int                    `json:"index,omitempty"`
+	Duration    int64                  `json:"durationMs,omitempty"`
+	ErrorClass  string                 `json:"errorClass,omitempty"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+}
+
+// SetLogFormat sets the format of messages written to STDERR; in the JSON
+// format, messages of the standard logger are written as JSON objects too.
+// Credentials in DevTools protocol messages are redacted in both formats.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText:
+		jsonLogEnabled = false
+		log.SetOutput(redactWriter{os.Stderr})
+		log.SetFlags(log.LstdFlags)
+	case LogFormatJSON:
+		jsonLogEnabled = true
+		log.SetOutput(redactWriter{jsonLogWriter{}})
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("Unknown log format: '%s'", format)
+	}
+	return nil
+}
+
+// JSONLogEnabled returns true if the JSON log format is used
+func JSONLogEnabled() bool {
+	return jsonLogEnabled
+}
+
+// writeLogEntry writes the entry as a single line of JSON
+func writeLogEntry(e *LogEntry) {
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		data, _ = json.Marshal(&LogEntry{
+			Time:    e.Time,
+			Level:   "error",
+			Message: fmt.Sprintf("Failed to encode log entry: %v", err),
+		})
+	}
+
+	stderr.Lock()
+	defer stderr.Unlock()
+
+	stderr.w.Write(append(data, '\n'))
+}
+
+// jsonLogWriter wraps messages of the standard logger into log entries
+type jsonLogWriter struct{}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	writeLogEntry(&LogEntry{
+		Level:   "info",
+		Message: strings.TrimRight(string(p), "\n"),
+	})
+	return len(p), nil
+}
+
+// Logger reports phases of the command execution and its result
+// as structured log events; it does nothing unless the JSON log format
+// is used, as the text format relies on regular verbose messages
+type Logger struct {
+	mutex       sync.Mutex
+	containerID string
+	phase       string
+
+	// the URL processed in batch mode and its 1-based index
+	url   string
+	index int
+}
+
+// Phase is a phase of the command execution being timed
+type Phase struct {
+	logger *Logger
+	name   string
+	start  time.Time
+}
+
+// SetContainerID sets the container ID reported with subsequent events
+func (l *Logger) SetContainerID(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.containerID = id
+}
+
+// ForURL returns a logger for a URL processed in batch mode,
+// whose events include the URL and its 1-based index
+func (l *Logger) ForURL(index int, url string) *Logger {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return &Logger{containerID: l.containerID, url: url, index: index}
+}
+
+// StartPhase marks the beginning of the phase; errors reported
+// by Result are attributed to the last started phase
+func (l *Logger) StartPhase(name string) *Phase {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.phase = name
+	return &Phase{logger: l, name: name, start: time.Now()}
+}
+
+// TimePhase is like StartPhase, but doesn't change the phase errors
+// are attributed to; it is used for cleanup after the command
+func (l *Logger) TimePhase(name string) *Phase {
+	return &Phase{logger: l, name: name, start: time.Now()}
+}
+
+// End reports successful completion of the phase
+// with its duration and optional details
+func (p *Phase) End(details map[string]interface{}) {
+	if !jsonLogEnabled {
+		return
+	}
+
+	p.logger.mutex.Lock()
+	containerID := p.logger.containerID
+	p.logger.mutex.Unlock()
+
+	writeLogEntry(&LogEntry{
+		Level:       "info",
+		Phase:       p.name,
+		ContainerID: containerID,
+		URL:         p.logger.url,
+		Index:       p.logger.index,
+		Duration:    int64(time.Since(p.start) / time.Millisecond),
+		Details:     details,
+	})
+}
+
+// Result reports the final result of the command execution: success
+// if err is nil, or an error of the given class and exit code
+func (l *Logger) Result(err error, errorClass string, exitCode int, duration time.Duration) {
+	if !jsonLogEnabled {
+		return
+	}
+
+	l.mutex.Lock()
+	e := &LogEntry{
+		Level:       "info",
+		Phase:       "result",
+		ContainerID: l.containerID,
+		URL:         l.url,
+		Index:       l.index,
+		Duration:    int64(duration / time.Millisecond),
+		Details: map[string]interface{}{
+			"success":  err == nil,
+			"exitCode": exitCode,
+		},
+	}
+	if err != nil {
+		e.Level = "error"
+		e.Message = err.Error()
+		e.ErrorClass = errorClass
+		e.Details["failedPhase"] = l.phase
+	}
+	l.mutex.Unlock()
+
+	writeLogEntry(e)
+}
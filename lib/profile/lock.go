@@ -0,0 +1,51 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ProfileLock is an exclusive lock on a profile that prevents
+// concurrent runs from using (and corrupting) the same profile
+type ProfileLock struct {
+	file *os.File
+}
+
+// Lock acquires an exclusive lock on the profile;
+// it fails immediately if the profile is already in use
+func Lock(name string) (*ProfileLock, error) {
+	err := ValidateName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := BaseDir()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := filepath.Join(dir, name+lockSuffix)
+	file, err := lockFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Profile '%s' is in use by another process", name)
+	}
+
+	return &ProfileLock{file: file}, nil
+}
+
+// Release releases the lock
+func (l *ProfileLock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	l.file = nil
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package profile
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockFile(file *os.File) error {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return file.Close()
+}
@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+package profile
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile opens the lock file without sharing, so that it can't be
+// opened again until closed; like flock on other platforms, the lock
+// is released by the system if the process dies, so a lock file
+// left behind by a crashed process doesn't block the profile
+func lockFile(filename string) (*os.File, error) {
+	path, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(
+		path,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, // no sharing
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), filename), nil
+}
+
+func unlockFile(file *os.File) error {
+	return file.Close()
+}
@@ -0,0 +1,306 @@
+// Package profile manages named persistent browser profiles
+// (Chrome user data directories) that can be mounted into containers
+package profile
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ContainerDir is the path the profile directory is mounted to
+// inside the container
+const ContainerDir = "/data/profile"
+
+const lockSuffix = ".lock"
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// BaseDir returns the directory where all profiles are stored:
+// $HC_PROFILES_DIR if set, or .hc/profiles in the home directory
+// of the user otherwise (e.g. %USERPROFILE% on Windows)
+func BaseDir() (string, error) {
+	dir := os.Getenv("HC_PROFILES_DIR")
+	if dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the profiles directory (set HC_PROFILES_DIR): %v", err)
+	}
+	return filepath.Join(home, ".hc", "profiles"), nil
+}
+
+// ValidateName checks if the profile name is valid
+func ValidateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf(
+			"Invalid profile name: '%s'. Profile name can only contain letters, digits, '.', '_' and '-'",
+			name,
+		)
+	}
+	return nil
+}
+
+// Path returns the absolute path to the profile directory
+func Path(name string) (string, error) {
+	err := ValidateName(name)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(dir, name))
+}
+
+// Exists returns true if the profile exists
+func Exists(name string) bool {
+	path, err := Path(name)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// List returns sorted names of all existing profiles
+func List() (names []string, err error) {
+	dir, err := BaseDir()
+	if err != nil {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() && ValidateName(e.Name()) == nil {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// Create creates a new empty profile
+func Create(name string) error {
+	path, err := Path(name)
+	if err != nil {
+		return err
+	}
+
+	if Exists(name) {
+		return fmt.Errorf("Profile '%s' already exists", name)
+	}
+
+	return os.MkdirAll(path, 0700)
+}
+
+// Delete removes the profile; the profile must not be in use
+func Delete(name string) error {
+	path, err := Path(name)
+	if err != nil {
+		return err
+	}
+
+	if !Exists(name) {
+		return fmt.Errorf("Profile '%s' does not exist", name)
+	}
+
+	lock, err := Lock(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	return os.RemoveAll(path)
+}
+
+// Export writes the profile contents to w as a gzipped tar archive;
+// the profile must not be in use
+func Export(name string, w io.Writer) error {
+	path, err := Path(name)
+	if err != nil {
+		return err
+	}
+
+	if !Exists(name) {
+		return fmt.Errorf("Profile '%s' does not exist", name)
+	}
+
+	lock, err := Lock(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, file)
+		if err != nil || rel == "." || isTransient(info.Name()) {
+			return err
+		}
+
+		// skip sockets, symlinks and other special files
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		err = tw.WriteHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Import creates a new profile from a gzipped tar archive
+// previously produced by Export
+func Import(name string, r io.Reader) (err error) {
+	// keep runs from using the profile while it's being imported
+	lock, err := Lock(name)
+	if err != nil {
+		return
+	}
+	defer lock.Release()
+
+	err = Create(name)
+	if err != nil {
+		return
+	}
+
+	path, _ := Path(name)
+
+	// don't leave a partially imported profile behind
+	defer func() {
+		if err != nil {
+			os.RemoveAll(path)
+		}
+	}()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		var header *tar.Header
+		header, err = tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+
+		target := filepath.Join(path, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, path+string(os.PathSeparator)) {
+			return fmt.Errorf("Invalid file path in profile archive: '%s'", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg:
+			err = extractFile(tr, target, os.FileMode(header.Mode).Perm())
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// RemoveTransientFiles removes Chrome's singleton lock files left
+// by a previous container, which would otherwise prevent Chrome
+// from using the profile under a different hostname
+func RemoveTransientFiles(name string) error {
+	path, err := Path(name)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if isTransient(e.Name()) {
+			err = os.Remove(filepath.Join(path, e.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isTransient(filename string) bool {
+	return strings.HasPrefix(filename, "Singleton")
+}
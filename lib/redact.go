@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces sensitive values in logged DevTools messages
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys lists (lowercase) HTTP headers and protocol parameters
+// whose values are never logged; headers passed by the user are added
+// with RedactHeader
+var sensitiveKeys = struct {
+	sync.RWMutex
+	m map[string]bool
+}{
+	m: map[string]bool{
+		"authorization":       true,
+		"proxy-authorization": true,
+		"cookie":              true,
+		"set-cookie":          true,
+		"username":            true,
+		"password":            true,
+	},
+}
+
+// RedactHeader makes values of the HTTP header redacted
+// in logged DevTools protocol messages
+func RedactHeader(name string) {
+	sensitiveKeys.Lock()
+	defer sensitiveKeys.Unlock()
+
+	sensitiveKeys.m[strings.ToLower(name)] = true
+}
+
+func isSensitive(key string) bool {
+	sensitiveKeys.RLock()
+	defer sensitiveKeys.RUnlock()
+
+	return sensitiveKeys.m[strings.ToLower(key)]
+}
+
+// sensitiveJSONValue matches sensitive values in messages
+// which are not valid JSON
+func sensitiveJSONValue() *regexp.Regexp {
+	sensitiveKeys.RLock()
+	defer sensitiveKeys.RUnlock()
+
+	var keys []string
+	for key := range sensitiveKeys.m {
+		keys = append(keys, regexp.QuoteMeta(key))
+	}
+	sort.Strings(keys)
+	return regexp.MustCompile(`(?i)"(` + strings.Join(keys, "|") + `)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+}
+
+// redactHeadersText redacts values of sensitive headers
+// in raw header text (e.g. `headersText`)
+func redactHeadersText(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && isSensitive(strings.TrimSpace(parts[0])) {
+			lines[i] = parts[0] + ": " + redactedValue
+			if strings.HasSuffix(line, "\r") {
+				lines[i] += "\r"
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactWriter removes credentials (authorization, cookie and user-specified
+// headers, cookie values, HTTP authentication credentials) from DevTools
+// protocol messages written to the standard logger with --verbose-devtools
+type redactWriter struct {
+	w io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	_, err := w.w.Write(redactMessage(p))
+	return len(p), err
+}
+
+// redactMessage redacts the protocol message (a JSON object with
+// `id` or `method`) following the log prefix; other log messages
+// are returned as is
+func redactMessage(p []byte) []byte {
+	i := bytes.IndexByte(p, '{')
+	if i < 0 {
+		return p
+	}
+
+	var message map[string]interface{}
+	if json.Unmarshal(p[i:], &message) != nil {
+		return sensitiveJSONValue().ReplaceAll(p, []byte(`"$1"$2"`+redactedValue+`"`))
+	}
+
+	_, hasID := message["id"]
+	_, hasMethod := message["method"]
+	if !hasID && !hasMethod {
+		return p
+	}
+
+	data, err := json.Marshal(redactValue(message))
+	if err != nil {
+		return p
+	}
+
+	out := append([]byte{}, p[:i]...)
+	out = append(out, data...)
+	return append(out, '\n')
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// cookies have both a name and a value, and so do headers
+		// in arrays of name/value entries (e.g. in Fetch domain)
+		name, hasName := v["name"].(string)
+		_, hasDomain := v["domain"]
+		_, hasURL := v["url"]
+		if hasName && (hasDomain || hasURL || isSensitive(name)) {
+			if _, ok := v["value"].(string); ok {
+				v["value"] = redactedValue
+			}
+		}
+
+		for key, value := range v {
+			if _, ok := value.(string); ok && isSensitive(key) {
+				v[key] = redactedValue
+				continue
+			}
+			if s, ok := value.(string); ok && key == "headersText" {
+				v[key] = redactHeadersText(s)
+				continue
+			}
+			v[key] = redactValue(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactUserHeaders(t *testing.T) {
+	RedactHeader("X-Api-Key")
+
+	messages := []string{
+		`send {"id":3,"method":"Network.setExtraHTTPHeaders","params":{"headers":{"X-Api-Key":"0123456789"}}}`,
+		`recv {"method":"Network.requestWillBeSent","params":{"request":{"url":"https://example.com/",` +
+			`"headers":{"x-api-key":"0123456789","Accept":"text/html"}}}}`,
+		`recv {"method":"Network.responseReceivedExtraInfo","params":` +
+			`{"headersText":"GET / HTTP/1.1\r\nX-Api-Key: 0123456789\r\nAccept: text/html\r\n"}}`,
+		`send {"id":4,"method":"Fetch.continueRequest","params":{"headers":[{"name":"X-API-KEY","value":"0123456789"}]}}`,
+		`send {"id":5,"method":"Network.setExtraHTTPHeaders","params":{"headers":{"X-Api-Key":"0123456789"`,
+	}
+
+	for _, m := range messages {
+		out := string(redactMessage([]byte(m)))
+		if strings.Contains(out, "0123456789") {
+			t.Errorf("header value is not redacted: %s", out)
+		}
+		if !strings.Contains(out, redactedValue) {
+			t.Errorf("redacted value is missing: %s", out)
+		}
+	}
+}
+
+func TestRedactKeepsOtherHeaders(t *testing.T) {
+	out := string(redactMessage([]byte(
+		`recv {"method":"Network.requestWillBeSent","params":{"request":{"headers":{"Accept":"text/html"}}}}`,
+	)))
+	if !strings.Contains(out, "text/html") || strings.Contains(out, redactedValue) {
+		t.Errorf("unexpected redaction: %s", out)
+	}
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+)
+
+// InputAction is a single user input action performed after the page is loaded
+type InputAction struct {
+	Kind  string
+	Value string
+}
+
+// inputActionFlag is a flag.Value that appends actions of a given kind
+// to a shared list, so that actions are performed in command-line order
+type inputActionFlag struct {
+	kind    string
+	actions *[]InputAction
+}
+
+// String implements flag.Value.String
+func (f *inputActionFlag) String() string {
+	return ""
+}
+
+// Set implements flag.Value.Set
+func (f *inputActionFlag) Set(value string) error {
+	*f.actions = append(*f.actions, InputAction{Kind: f.kind, Value: value})
+	return nil
+}
+
+// InputOptions holds user input actions (clicks, typing, key presses,
+// drag-and-drop and file uploads) shared by page-loading commands
+type InputOptions struct {
+	actions   []InputAction
+	typeDelay time.Duration
+	wait      time.Duration
+}
+
+// Init specifies command-line flags to parse
+func (o *InputOptions) Init() {
+	flag.Var(
+		&inputActionFlag{"click", &o.actions},
+		"click",
+		"CSS selector of an element to click after the page is loaded (can be repeated)",
+	)
+	flag.Var(
+		&inputActionFlag{"type", &o.actions},
+		"type",
+		"Text to type into the focused element after the page is loaded (can be repeated)",
+	)
+	flag.Var(
+		&inputActionFlag{"press", &o.actions},
+		"press",
+		"Key to press after the page is loaded, e.g. 'Enter', 'Tab' or 'ArrowDown' (can be repeated)",
+	)
+	flag.Var(
+		&inputActionFlag{"drag", &o.actions},
+		"drag",
+		"Drag-and-drop in '<source-selector> >> <target-selector>' format (can be repeated)",
+	)
+	flag.Var(
+		&inputActionFlag{"upload", &o.actions},
+		"upload",
+		"Files to set on a file input in '<selector>=<file>[,<file>...]' format (can be repeated)",
+	)
+	flag.DurationVar(&o.typeDelay, "type-delay", 50*time.Millisecond, "Delay between key presses when typing")
+	flag.DurationVar(&o.wait, "input-wait", 500*time.Millisecond, "Extra time to wait after performing input actions")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *InputOptions) Validate() {
+	for _, a := range o.actions {
+		var err error
+		switch a.Kind {
+		case "drag":
+			_, _, err = splitDrag(a.Value)
+		case "upload":
+			_, _, err = splitUpload(a.Value)
+		}
+		if err != nil {
+			StopOnUsageError(err.Error())
+		}
+	}
+}
+
+// Enabled returns true if any input actions were requested
+func (o *InputOptions) Enabled() bool {
+	return len(o.actions) > 0
+}
+
+// Apply performs input actions in the order they were specified;
+// files to upload are copied to the remote first
+func (o *InputOptions) Apply(ctx context.Context, remote *godet.RemoteDebugger, host lib.Host) (err error) {
+	if !o.Enabled() {
+		return
+	}
+
+	verbose := host.GetVerbose()
+
+	for _, a := range o.actions {
+		if ctx.Err() != nil {
+			return ContextError(ctx)
+		}
+
+		if verbose {
+			if a.Kind == "type" {
+				log.Printf("Typing %d characters", len([]rune(a.Value)))
+			} else {
+				log.Printf("Performing '%s' action: %s", a.Kind, a.Value)
+			}
+		}
+
+		switch a.Kind {
+		case "click":
+			err = ClickElement(ctx, remote, a.Value)
+		case "type":
+			err = TypeText(ctx, remote, a.Value, o.typeDelay)
+		case "press":
+			err = PressKey(ctx, remote, a.Value)
+		case "drag":
+			from, to, _ := splitDrag(a.Value)
+			err = DragAndDrop(ctx, remote, from, to)
+		case "upload":
+			err = o.upload(ctx, remote, host, a.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("'%s' action failed: %v", a.Kind, err)
+		}
+	}
+
+	return Sleep(ctx, o.wait)
+}
+
+func (o *InputOptions) upload(ctx context.Context, remote *godet.RemoteDebugger, host lib.Host, value string) error {
+	selector, files, _ := splitUpload(value)
+
+	remoteFiles := make([]string, len(files))
+	for i, file := range files {
+		remoteFile, err := host.CopyFileToRemote(file)
+		if err != nil {
+			return err
+		}
+		remoteFiles[i] = remoteFile
+	}
+
+	return UploadFiles(ctx, remote, selector, remoteFiles)
+}
+
+func splitDrag(value string) (from string, to string, err error) {
+	parts := strings.SplitN(value, ">>", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		err = fmt.Errorf("Invalid drag-and-drop definition: '%s'. Expected format: '<source-selector> >> <target-selector>'", value)
+		return
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+func splitUpload(value string) (selector string, files []string, err error) {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		err = fmt.Errorf("Invalid upload definition: '%s'. Expected format: '<selector>=<file>[,<file>...]'", value)
+		return
+	}
+
+	for _, file := range strings.Split(value[i+1:], ",") {
+		if _, err = os.Stat(file); err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	return value[:i], files, nil
+}
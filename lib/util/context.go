@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/raff/godet"
+)
+
+// ContextError returns an error describing why the context is done:
+// the deadline was exceeded or the command was interrupted
+func ContextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return Timeoutf("Request timed out")
+	default:
+		return WithExitCode(ExitInterrupted, fmt.Errorf("Interrupted"))
+	}
+}
+
+// Sleep pauses for the given duration, or until the context is done,
+// in which case it returns ContextError
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ContextError(ctx)
+	}
+}
+
+// SendRequest sends the DevTools protocol request, waiting for the response
+// until the context is done, in which case it returns ContextError;
+// requests can't be cancelled, so the request is abandoned.
+// Errors caused by the remote being closed once the context is done
+// are reported as ContextError too.
+func SendRequest(ctx context.Context, session Session, method string, params godet.Params) (map[string]interface{}, error) {
+	type response struct {
+		res map[string]interface{}
+		err error
+	}
+
+	done := make(chan response, 1)
+	Go(func() {
+		res, err := session.SendRequest(method, params)
+		done <- response{res, err}
+	})
+
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() != nil {
+			return nil, ContextError(ctx)
+		}
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, ContextError(ctx)
+	}
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Cookie describes a browser cookie
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#type-CookieParam)
+type Cookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	URL      string  `json:"url,omitempty"`
+	Domain   string  `json:"domain,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Secure   bool    `json:"secure,omitempty"`
+	HTTPOnly bool    `json:"httpOnly,omitempty"`
+	SameSite string  `json:"sameSite,omitempty"`
+	Expires  float64 `json:"expires,omitempty"`
+}
+
+const netscapeHTTPOnlyPrefix = "#HttpOnly_"
+
+// ParseCookie parses a cookie definition in
+// `name=value[;domain=...][;path=...][;secure][;httpOnly][;sameSite=...][;expires=<unix-time>]`
+// format
+func ParseCookie(s string) (cookie Cookie, err error) {
+	parts := strings.Split(s, ";")
+
+	nv := strings.SplitN(parts[0], "=", 2)
+	if len(nv) != 2 || strings.TrimSpace(nv[0]) == "" {
+		err = fmt.Errorf("Invalid cookie: expected 'name=value[;attribute=value...]' format")
+		return
+	}
+	cookie.Name = strings.TrimSpace(nv[0])
+	cookie.Value = strings.TrimSpace(nv[1])
+
+	for _, attr := range parts[1:] {
+		kv := strings.SplitN(attr, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+
+		switch key {
+		case "":
+			continue
+		case "domain":
+			cookie.Domain = value
+		case "path":
+			cookie.Path = value
+		case "url":
+			cookie.URL = value
+		case "secure":
+			cookie.Secure = true
+		case "httponly":
+			cookie.HTTPOnly = true
+		case "samesite":
+			cookie.SameSite = value
+		case "expires":
+			cookie.Expires, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				err = fmt.Errorf("Invalid cookie expiration time '%s': expected Unix time", value)
+				return
+			}
+		default:
+			err = fmt.Errorf("Unknown cookie attribute: '%s'", key)
+			return
+		}
+	}
+	return
+}
+
+// ReadCookieFile reads cookies from a file in either JSON format
+// (an array of cookie objects or an output of `hc cookies` command)
+// or Netscape cookies.txt format
+func ReadCookieFile(filename string) (cookies []Cookie, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+
+	// an array of cookies
+	if strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &cookies)
+		if err != nil {
+			err = fmt.Errorf("Failed to parse cookie file [%s]: %v", filename, err)
+		}
+		return
+	}
+
+	// an output of `hc cookies` command
+	if strings.HasPrefix(trimmed, "{") {
+		dump := &StorageDump{}
+		err = json.Unmarshal(data, dump)
+		if err != nil {
+			err = fmt.Errorf("Failed to parse cookie file [%s]: %v", filename, err)
+		}
+		return dump.Cookies, err
+	}
+
+	cookies, err = ParseNetscapeCookies(string(data))
+	if err != nil {
+		err = fmt.Errorf("Failed to parse cookie file [%s]: %v", filename, err)
+	}
+	return
+}
+
+// ParseNetscapeCookies parses cookies in Netscape cookies.txt format
+func ParseNetscapeCookies(data string) (cookies []Cookie, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, netscapeHTTPOnlyPrefix) {
+			httpOnly = true
+			line = strings.TrimPrefix(line, netscapeHTTPOnlyPrefix)
+		}
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNum, len(fields))
+		}
+
+		expires, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiration time '%s'", lineNum, fields[4])
+		}
+
+		cookies = append(cookies, Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Expires:  expires,
+			Name:     fields[5],
+			Value:    fields[6],
+			HTTPOnly: httpOnly,
+		})
+	}
+	return cookies, scanner.Err()
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/raff/godet"
+)
+
+// Geolocation defines a geographical position to emulate
+type Geolocation struct {
+	Latitude  float64
+	Longitude float64
+	Accuracy  float64
+}
+
+// EmulationOptions holds user agent, locale, timezone and geolocation
+// overrides shared by page-loading commands
+type EmulationOptions struct {
+	userAgent        string
+	acceptLanguage   string
+	locale           string
+	timezone         string
+	geolocationParam string
+	geolocation      *Geolocation
+}
+
+// Init specifies command-line flags to parse
+func (o *EmulationOptions) Init() {
+	flag.StringVar(&o.userAgent, "user-agent", "", "User agent string to use")
+	flag.StringVar(&o.acceptLanguage, "accept-language", "", "Value of the Accept-Language HTTP header, e.g. 'de-DE,de;q=0.9'")
+	flag.StringVar(&o.locale, "locale", "", "ICU locale to emulate, e.g. 'de_DE'")
+	flag.StringVar(&o.timezone, "timezone", "", "Timezone ID to emulate, e.g. 'Europe/Berlin'")
+	flag.StringVar(&o.geolocationParam, "geolocation", "", "Geolocation to emulate in 'latitude,longitude[,accuracy]' format")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *EmulationOptions) Validate() {
+	if o.geolocationParam == "" {
+		return
+	}
+
+	var err error
+	o.geolocation, err = ParseGeolocation(o.geolocationParam)
+	if err != nil {
+		StopOnUsageError(err.Error())
+	}
+}
+
+// Apply applies the overrides; it should be called right after
+// connecting to the remote
+func (o *EmulationOptions) Apply(remote *godet.RemoteDebugger) (err error) {
+	if o.userAgent != "" || o.acceptLanguage != "" {
+		userAgent := o.userAgent
+		if userAgent == "" {
+			userAgent, err = GetUserAgent(remote)
+			if err != nil {
+				return
+			}
+		}
+
+		err = SetUserAgentOverride(remote, userAgent, o.acceptLanguage)
+		if err != nil {
+			return
+		}
+	}
+
+	if o.locale != "" {
+		err = SetLocaleOverride(remote, o.locale)
+		if err != nil {
+			return
+		}
+	}
+
+	if o.timezone != "" {
+		err = SetTimezoneOverride(remote, o.timezone)
+		if err != nil {
+			return
+		}
+	}
+
+	if o.geolocation != nil {
+		err = GrantPermissions(remote, "geolocation")
+		if err != nil {
+			return
+		}
+
+		err = SetGeolocationOverride(
+			remote,
+			o.geolocation.Latitude,
+			o.geolocation.Longitude,
+			o.geolocation.Accuracy,
+		)
+	}
+	return
+}
+
+// ParseGeolocation parses geolocation in 'latitude,longitude[,accuracy]' format
+func ParseGeolocation(s string) (g *Geolocation, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("Invalid geolocation: '%s'. Expected format: 'latitude,longitude[,accuracy]'", s)
+	}
+
+	values := make([]float64, len(parts))
+	for i := range parts {
+		values[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid geolocation: '%s': %v", s, err)
+		}
+	}
+
+	g = &Geolocation{Latitude: values[0], Longitude: values[1]}
+	if len(values) == 3 {
+		g.Accuracy = values[2]
+	}
+
+	if g.Latitude < -90 || g.Latitude > 90 {
+		return nil, fmt.Errorf("Latitude must be in [-90, 90] range, got %v", g.Latitude)
+	}
+	if g.Longitude < -180 || g.Longitude > 180 {
+		return nil, fmt.Errorf("Longitude must be in [-180, 180] range, got %v", g.Longitude)
+	}
+	if g.Accuracy < 0 {
+		return nil, fmt.Errorf("Accuracy must not be negative, got %v", g.Accuracy)
+	}
+	return
+}
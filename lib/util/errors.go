@@ -0,0 +1,166 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/iafan/hc/lib"
+)
+
+// Process exit codes (see the 'Exit codes' section in README)
+const (
+	ExitUsage             = 2  // invalid command-line arguments
+	ExitGeneric           = 3  // generic error
+	ExitOutputFile        = 4  // output file can't be opened for writing
+	ExitInterrupted       = 5  // interrupted by a signal
+	ExitTimeout           = 6  // deadline exceeded or wait conditions not met
+	ExitDNSError          = 7  // host name can't be resolved
+	ExitConnectionRefused = 8  // connection refused
+	ExitTLSError          = 9  // TLS handshake or certificate error
+	ExitHTTPError         = 10 // main document HTTP status matches --fail-on-status
+	ExitScriptError       = 11 // script threw an exception
+)
+
+// exitCodeClasses maps exit codes to error classes reported
+// in structured logs
+var exitCodeClasses = map[int]string{
+	ExitUsage:             "usage",
+	ExitOutputFile:        "output-file",
+	ExitInterrupted:       "interrupted",
+	ExitTimeout:           "timeout",
+	ExitDNSError:          "dns",
+	ExitConnectionRefused: "connection-refused",
+	ExitTLSError:          "tls",
+	ExitHTTPError:         "http",
+	ExitScriptError:       "script",
+}
+
+// ExitError is an error that makes the process exit
+// with a specific exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+// Error implements error.Error
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// WithExitCode annotates the error with the exit code
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// ClassError is an error with a class describing its source
+// (e.g. 'docker' or 'devtools') for structured logs
+type ClassError struct {
+	Class string
+	Err   error
+}
+
+// Error implements error.Error
+func (e *ClassError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ClassError) Unwrap() error {
+	return e.Err
+}
+
+// WithErrorClass annotates the error with the error class
+func WithErrorClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ClassError{Class: class, Err: err}
+}
+
+// OutputError is an error of a command that has reported the failure
+// in its output (e.g. a report of failed steps), so the output file
+// is written even though the command fails
+type OutputError struct {
+	Err error
+}
+
+// Error implements error.Error
+func (e *OutputError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// WithOutput annotates the error to keep the output of the command
+func WithOutput(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OutputError{Err: err}
+}
+
+// KeepOutput returns true if the output of the command
+// that returned the error should be written
+func KeepOutput(err error) bool {
+	var e *OutputError
+	return err == nil || errors.As(err, &e)
+}
+
+// ErrorClass returns the class of the error: the one it was annotated
+// with, the one derived from its exit code, or 'error'
+func ErrorClass(err error) string {
+	var e *ClassError
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	if class, ok := exitCodeClasses[ExitCode(err)]; ok {
+		return class
+	}
+	return "error"
+}
+
+// Timeoutf formats a timeout error that makes the process
+// exit with ExitTimeout
+func Timeoutf(format string, a ...interface{}) error {
+	return WithExitCode(ExitTimeout, fmt.Errorf(format, a...))
+}
+
+// ExitCode returns the exit code for the error:
+// the one it was annotated with, or ExitGeneric
+func ExitCode(err error) int {
+	var e *ExitError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ExitGeneric
+}
+
+// StopOnUsageError reports invalid command-line arguments and exits
+// with ExitUsage; in the JSON log format, the message is reported
+// in the result record
+func StopOnUsageError(message string) {
+	message = strings.TrimRight(message, "\n")
+
+	if lib.JSONLogEnabled() {
+		err := WithExitCode(ExitUsage, errors.New(message))
+
+		var logger lib.Logger
+		logger.Result(err, ErrorClass(err), ExitUsage, 0)
+	} else {
+		os.Stderr.WriteString(message + "\n")
+	}
+	os.Exit(ExitUsage)
+}
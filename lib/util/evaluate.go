@@ -0,0 +1,115 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/raff/godet"
+)
+
+// ScriptError is an exception thrown by evaluated code
+// or a rejection of the promise it returned
+type ScriptError struct {
+	Message string
+	Stack   []string
+}
+
+func (e *ScriptError) Error() string {
+	if len(e.Stack) == 0 {
+		return e.Message
+	}
+	return e.Message + "\n    at " + strings.Join(e.Stack, "\n    at ")
+}
+
+// Evaluate evaluates the JavaScript expression in the execution context
+// and returns its value; if the value is a promise, it is awaited until
+// the context is done. Exceptions and rejected promises are reported
+// as ScriptError with the JavaScript stack trace
+func Evaluate(ctx context.Context, ec *ExecutionContext, expr string) (interface{}, error) {
+	params := godet.Params{
+		"expression":    expr,
+		"returnByValue": true,
+		"awaitPromise":  true,
+	}
+	if ec.ContextID != 0 {
+		params["contextId"] = ec.ContextID
+	}
+
+	res, err := SendRequest(ctx, ec.Session, "Runtime.evaluate", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if details, ok := res["exceptionDetails"].(map[string]interface{}); ok {
+		return nil, WithExitCode(ExitScriptError, newScriptError(details))
+	}
+
+	result, _ := res["result"].(map[string]interface{})
+	if v, ok := result["unserializableValue"]; ok {
+		// NaN, Infinity, -0 or BigInt
+		return v, nil
+	}
+	return result["value"], nil
+}
+
+// EvaluateFunc evaluates the code in the scope of a function, so that
+// it can use 'return' (like godet's EvaluateWrap), until the context
+// is done; exceptions are reported as errors of the caller rather than
+// as script errors with ExitScriptError
+func EvaluateFunc(ctx context.Context, session Session, code string) (interface{}, error) {
+	res, err := Evaluate(ctx, &ExecutionContext{Session: session}, fmt.Sprintf("(function() {\n%s\n})()", code))
+
+	var e *ScriptError
+	if errors.As(err, &e) {
+		return nil, errors.New(e.Message)
+	}
+	return res, err
+}
+
+// newScriptError builds the error from Runtime.ExceptionDetails
+func newScriptError(details map[string]interface{}) *ScriptError {
+	e := &ScriptError{}
+
+	text, _ := details["text"].(string)
+	exception, _ := details["exception"].(map[string]interface{})
+
+	// description of Error objects contains the message and the stack
+	if description, ok := exception["description"].(string); ok {
+		lines := strings.Split(description, "\n")
+		e.Message = text + ": " + lines[0]
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "at ") {
+				e.Stack = append(e.Stack, strings.TrimPrefix(line, "at "))
+			}
+		}
+		if len(e.Stack) > 0 {
+			return e
+		}
+	} else if value, ok := exception["value"]; ok {
+		data, _ := json.Marshal(value)
+		e.Message = text + ": " + string(data)
+	} else {
+		e.Message = text
+	}
+
+	stackTrace, _ := details["stackTrace"].(map[string]interface{})
+	frames, _ := stackTrace["callFrames"].([]interface{})
+	for _, f := range frames {
+		frame, _ := f.(map[string]interface{})
+		name, _ := frame["functionName"].(string)
+		if name == "" {
+			name = "<anonymous>"
+		}
+		url, _ := frame["url"].(string)
+		line, _ := frame["lineNumber"].(float64)
+		column, _ := frame["columnNumber"].(float64)
+
+		// line and column numbers are 0-based
+		e.Stack = append(e.Stack, fmt.Sprintf("%s (%s:%d:%d)", name, url, int(line)+1, int(column)+1))
+	}
+	return e
+}
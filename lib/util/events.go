@@ -0,0 +1,85 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/raff/godet"
+)
+
+// godet supports only one callback per event, so events are dispatched
+// to multiple listeners registered via AddEventListener
+var listeners = struct {
+	sync.Mutex
+	m map[*godet.RemoteDebugger]map[string][]*listener
+}{
+	m: make(map[*godet.RemoteDebugger]map[string][]*listener),
+}
+
+type listener struct {
+	cb godet.EventCallback
+}
+
+// AddEventListener registers a callback for a DevTools event;
+// unlike godet's CallbackEvent, it doesn't replace previously
+// registered callbacks for the same event; the returned function
+// unregisters the callback
+func AddEventListener(remote *godet.RemoteDebugger, method string, cb godet.EventCallback) (remove func()) {
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	methods := listeners.m[remote]
+	if methods == nil {
+		methods = make(map[string][]*listener)
+		listeners.m[remote] = methods
+	}
+
+	if len(methods[method]) == 0 {
+		// listeners run on the DevTools connection goroutine
+		remote.CallbackEvent(method, func(params godet.Params) {
+			defer HandlePanic()
+
+			listeners.Lock()
+			callbacks := listeners.m[remote][method]
+			listeners.Unlock()
+
+			for _, l := range callbacks {
+				l.cb(params)
+			}
+		})
+	}
+
+	l := &listener{cb: cb}
+	methods[method] = append(methods[method], l)
+
+	return func() {
+		removeEventListener(remote, method, l)
+	}
+}
+
+func removeEventListener(remote *godet.RemoteDebugger, method string, l *listener) {
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	methods := listeners.m[remote]
+	if methods == nil {
+		return
+	}
+
+	// the slice may be in use by a dispatching goroutine,
+	// so a new one is created instead of modifying it
+	var callbacks []*listener
+	for _, c := range methods[method] {
+		if c != l {
+			callbacks = append(callbacks, c)
+		}
+	}
+	methods[method] = callbacks
+}
+
+// RemoveEventListeners unregisters all listeners of the remote
+func RemoveEventListeners(remote *godet.RemoteDebugger) {
+	listeners.Lock()
+	defer listeners.Unlock()
+
+	delete(listeners.m, remote)
+}
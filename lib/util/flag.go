@@ -0,0 +1,17 @@
+package util
+
+import "strings"
+
+// StringList is a flag.Value that collects values of a repeatable flag
+type StringList []string
+
+// String implements flag.Value.String
+func (l *StringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+// Set implements flag.Value.Set
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
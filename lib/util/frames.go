@@ -0,0 +1,631 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+)
+
+// frameWaitTimeout limits the time to wait for the frame
+// and its execution context to appear
+const frameWaitTimeout = 2 * time.Second
+
+// Session sends DevTools protocol requests to a target:
+// the page itself (*godet.RemoteDebugger) or an out-of-process
+// frame attached to it
+type Session interface {
+	SendRequest(method string, params godet.Params) (map[string]interface{}, error)
+}
+
+// ExecutionContext identifies where code is evaluated: the session
+// of the page or of an out-of-process frame, and the execution context
+// in it (0 for the main world of the session's main frame)
+type ExecutionContext struct {
+	Session   Session
+	ContextID int
+
+	release func()
+}
+
+// Release stops tracking frame targets used by the execution context;
+// the context can't be used after that
+func (ec *ExecutionContext) Release() {
+	if ec.release != nil {
+		ec.release()
+		ec.release = nil
+	}
+}
+
+// eventSession is a session which reports DevTools events
+type eventSession interface {
+	Session
+	addEventListener(method string, cb godet.EventCallback) (remove func())
+}
+
+// remoteSession is the session of the page itself
+type remoteSession struct {
+	*godet.RemoteDebugger
+}
+
+func (s remoteSession) addEventListener(method string, cb godet.EventCallback) func() {
+	return AddEventListener(s.RemoteDebugger, method, cb)
+}
+
+// frameInfo describes a frame found in frame trees
+type frameInfo struct {
+	id      string
+	name    string
+	url     string
+	session eventSession
+	// true if the frame is the main frame of the session
+	root bool
+}
+
+// FindExecutionContext returns the execution context of the frame
+// matching frameSpec (its name, a URL substring or a CSS selector
+// of the frame element, tried in this order on child frames only;
+// the main frame if empty),
+// in the isolated world with the given name (the main world if empty).
+// Out-of-process frames (e.g. cross-origin iframes), including the ones
+// nested in other out-of-process frames, are found by attaching to their
+// targets; call Release when the returned context is no longer used.
+func FindExecutionContext(
+	ctx context.Context, remote *godet.RemoteDebugger, frameSpec string, worldName string,
+) (ec *ExecutionContext, err error) {
+	frameCtx, cancel := context.WithTimeout(ctx, frameWaitTimeout)
+	defer cancel()
+
+	var targets *frameTargets
+	if frameSpec != "" {
+		targets = newFrameTargets(ctx)
+		defer func() {
+			if err != nil {
+				targets.close()
+			}
+		}()
+
+		err = targets.attach(remoteSession{remote})
+		if err != nil {
+			return nil, frameError(ctx, err)
+		}
+	}
+
+	// targets are attached asynchronously, and frames may still
+	// be loading, so look for the frame until it is found
+	var frame *frameInfo
+	for {
+		frame, err = findFrame(frameCtx, remote, targets, frameSpec)
+		if err != nil || frame != nil {
+			break
+		}
+
+		if Sleep(frameCtx, 100*time.Millisecond) != nil {
+			if ctx.Err() != nil {
+				return nil, ContextError(ctx)
+			}
+			return nil, fmt.Errorf("Frame not found: '%s'", frameSpec)
+		}
+	}
+	if err != nil {
+		return nil, frameError(ctx, err)
+	}
+
+	ec = &ExecutionContext{Session: frame.session}
+	if targets != nil {
+		ec.release = targets.close
+	}
+
+	if worldName != "" {
+		var res map[string]interface{}
+		res, err = SendRequest(frameCtx, frame.session, "Page.createIsolatedWorld", godet.Params{
+			"frameId":   frame.id,
+			"worldName": worldName,
+		})
+		if err != nil {
+			return nil, frameError(ctx, err)
+		}
+		id, _ := res["executionContextId"].(float64)
+		ec.ContextID = int(id)
+		return
+	}
+
+	if frame.root {
+		return
+	}
+
+	// the main world of a child frame in the same process
+	ec.ContextID, err = defaultContextID(frameCtx, frame.session, frame.id)
+	if err != nil {
+		return nil, frameError(ctx, err)
+	}
+	return
+}
+
+// frameError reports that the parent context is done, if it is
+func frameError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ContextError(ctx)
+	}
+	return err
+}
+
+// findFrame returns the frame matching the spec, or nil if not found
+func findFrame(ctx context.Context, remote *godet.RemoteDebugger, targets *frameTargets, spec string) (*frameInfo, error) {
+	frames, err := frameTree(ctx, remoteSession{remote})
+	if err != nil {
+		return nil, err
+	}
+
+	if spec == "" {
+		return frames[0], nil
+	}
+
+	// out-of-process frames are the main frames of their targets, and their
+	// child frames are only reported by the targets, so frame trees of all
+	// attached targets are merged with the one of the page
+	for _, s := range targets.list() {
+		nested, err := frameTree(ctx, s)
+		if err != nil {
+			// the target may be detaching
+			continue
+		}
+		frames = mergeFrames(frames, nested)
+	}
+
+	// the main frame is only used without the spec, so that a spec
+	// matching the page itself (e.g. its URL) isn't silently accepted
+	for _, f := range frames[1:] {
+		if f.name == spec {
+			return f, nil
+		}
+	}
+
+	for _, f := range frames[1:] {
+		if strings.Contains(f.url, spec) {
+			return f, nil
+		}
+	}
+
+	// the frame element is looked up in the main frame
+	frameID, err := frameIDBySelector(ctx, remote, spec)
+	if err != nil || frameID == "" {
+		return nil, err
+	}
+
+	for _, f := range frames {
+		if f.id == frameID {
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// frameTree returns frames of the session, starting with its main frame
+func frameTree(ctx context.Context, session eventSession) ([]*frameInfo, error) {
+	res, err := SendRequest(ctx, session, "Page.getFrameTree", godet.Params{})
+	if err != nil {
+		return nil, err
+	}
+
+	tree, _ := res["frameTree"].(map[string]interface{})
+	var frames []*frameInfo
+	collectFrames(tree, session, &frames)
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("Failed to get the frame tree")
+	}
+
+	frames[0].root = true
+	return frames, nil
+}
+
+// mergeFrames adds frames of a target to the list; an out-of-process frame
+// is also listed by its parent session, but can only be used via its target
+func mergeFrames(frames []*frameInfo, nested []*frameInfo) []*frameInfo {
+	for _, f := range nested {
+		found := false
+		for i, existing := range frames {
+			if existing.id == f.id {
+				if f.root {
+					frames[i] = f
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+func collectFrames(tree map[string]interface{}, session eventSession, frames *[]*frameInfo) {
+	if frame, ok := tree["frame"].(map[string]interface{}); ok {
+		f := &frameInfo{session: session}
+		f.id, _ = frame["id"].(string)
+		f.name, _ = frame["name"].(string)
+		f.url, _ = frame["url"].(string)
+		*frames = append(*frames, f)
+	}
+
+	children, _ := tree["childFrames"].([]interface{})
+	for _, child := range children {
+		if c, ok := child.(map[string]interface{}); ok {
+			collectFrames(c, session, frames)
+		}
+	}
+}
+
+// frameIDBySelector returns the ID of the frame of the element
+// matching the CSS selector, or an empty string if there's none
+func frameIDBySelector(ctx context.Context, remote *godet.RemoteDebugger, selector string) (string, error) {
+	// invalid selectors (e.g. URL masks) don't match anything
+	res, err := SendRequest(ctx, remote, "Runtime.evaluate", godet.Params{
+		"expression": fmt.Sprintf(
+			"(function() { try { return document.querySelector(%s) } catch (e) { return null } })()",
+			JSString(selector),
+		),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result, _ := res["result"].(map[string]interface{})
+	objectID, _ := result["objectId"].(string)
+	if objectID == "" {
+		return "", nil
+	}
+	defer remote.SendRequest("Runtime.releaseObject", godet.Params{"objectId": objectID})
+
+	res, err = SendRequest(ctx, remote, "DOM.describeNode", godet.Params{"objectId": objectID})
+	if err != nil {
+		return "", err
+	}
+
+	node, _ := res["node"].(map[string]interface{})
+	frameID, _ := node["frameId"].(string)
+	return frameID, nil
+}
+
+// defaultContextID returns the ID of the main world execution context
+// of the frame; existing contexts are reported when Runtime domain
+// is enabled
+func defaultContextID(ctx context.Context, session eventSession, frameID string) (int, error) {
+	found := make(chan int, 1)
+
+	remove := session.addEventListener("Runtime.executionContextCreated", func(params godet.Params) {
+		c, _ := params["context"].(map[string]interface{})
+		auxData, _ := c["auxData"].(map[string]interface{})
+		isDefault, _ := auxData["isDefault"].(bool)
+		if auxData["frameId"] != frameID || !isDefault {
+			return
+		}
+
+		id, _ := c["id"].(float64)
+		select {
+		case found <- int(id):
+		default:
+		}
+	})
+	defer remove()
+
+	_, err := SendRequest(ctx, session, "Runtime.enable", godet.Params{})
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case id := <-found:
+		return id, nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("Execution context of frame %s not found", frameID)
+	}
+}
+
+// frameTargets tracks sessions of targets attached to the page
+// and to its out-of-process frames; for out-of-process frames,
+// the target ID is the frame ID
+type frameTargets struct {
+	// the context of the command; targets are attached
+	// while the execution context is in use
+	ctx context.Context
+
+	mutex     sync.Mutex
+	sessions  map[string]*targetSession
+	listeners []func()
+	parents   []eventSession
+	closed    bool
+}
+
+func newFrameTargets(ctx context.Context) *frameTargets {
+	return &frameTargets{ctx: ctx, sessions: make(map[string]*targetSession)}
+}
+
+// attach enables auto-attaching to targets of the session,
+// and tracks the attached targets and their messages
+func (t *frameTargets) attach(parent eventSession) error {
+	t.listen(parent, "Target.attachedToTarget", func(params godet.Params) {
+		info, _ := params["targetInfo"].(map[string]interface{})
+		if info["type"] != "iframe" {
+			return
+		}
+
+		targetID, _ := info["targetId"].(string)
+		sessionID, _ := params["sessionId"].(string)
+		s := newTargetSession(parent, sessionID)
+
+		t.mutex.Lock()
+		t.sessions[targetID] = s
+		t.mutex.Unlock()
+
+		// out-of-process frames nested in the frame are its targets;
+		// requests can't be sent from the listener, as responses are
+		// dispatched by the same goroutine
+		Go(func() {
+			t.attach(s)
+		})
+	})
+
+	t.listen(parent, "Target.receivedMessageFromTarget", func(params godet.Params) {
+		if s := t.session(params["sessionId"]); s != nil {
+			message, _ := params["message"].(string)
+			s.receive(message)
+		}
+	})
+
+	t.listen(parent, "Target.detachedFromTarget", func(params godet.Params) {
+		if s := t.session(params["sessionId"]); s != nil {
+			t.remove(s)
+			s.close("Frame detached")
+		}
+	})
+
+	t.mutex.Lock()
+	t.parents = append(t.parents, parent)
+	t.mutex.Unlock()
+
+	_, err := SendRequest(t.ctx, parent, "Target.setAutoAttach", godet.Params{
+		"autoAttach":             true,
+		"waitForDebuggerOnStart": false,
+		"flatten":                false,
+	})
+	return err
+}
+
+// listen adds a listener which is removed on close
+func (t *frameTargets) listen(session eventSession, method string, cb godet.EventCallback) {
+	remove := session.addEventListener(method, cb)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		remove()
+		return
+	}
+	t.listeners = append(t.listeners, remove)
+}
+
+// session returns the target session with the given ID
+func (t *frameTargets) session(sessionID interface{}) *targetSession {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, s := range t.sessions {
+		if s.sessionID == sessionID {
+			return s
+		}
+	}
+	return nil
+}
+
+// remove stops tracking the session of a detached target
+func (t *frameTargets) remove(session *targetSession) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for targetID, s := range t.sessions {
+		if s == session {
+			delete(t.sessions, targetID)
+		}
+	}
+}
+
+// list returns all attached target sessions
+func (t *frameTargets) list() []*targetSession {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	sessions := make([]*targetSession, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// close disables auto-attaching, removes listeners
+// and fails pending requests of all sessions
+func (t *frameTargets) close() {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return
+	}
+	t.closed = true
+	parents := t.parents
+	listeners := t.listeners
+	t.listeners = nil
+	sessions := t.sessions
+	t.sessions = make(map[string]*targetSession)
+	t.mutex.Unlock()
+
+	// nested targets first, while their messages are still dispatched;
+	// errors are ignored, as targets may be detached already
+	for i := len(parents) - 1; i >= 0; i-- {
+		SendRequest(t.ctx, parents[i], "Target.setAutoAttach", godet.Params{
+			"autoAttach":             false,
+			"waitForDebuggerOnStart": false,
+		})
+	}
+
+	for _, remove := range listeners {
+		remove()
+	}
+	for _, s := range sessions {
+		s.close("Frame session closed")
+	}
+}
+
+// targetSession sends requests to an attached target
+// via `Target.sendMessageToTarget` of its parent session
+type targetSession struct {
+	parent    Session
+	sessionID string
+
+	mutex   sync.Mutex
+	lastID  int
+	pending map[int]chan targetMessage
+	events  map[string][]*listener
+	closed  string
+}
+
+// targetMessage is a response or an event of the target
+type targetMessage struct {
+	ID     int                    `json:"id"`
+	Result map[string]interface{} `json:"result"`
+	Error  *targetError           `json:"error"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type targetError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newTargetSession(parent Session, sessionID string) *targetSession {
+	return &targetSession{
+		parent:    parent,
+		sessionID: sessionID,
+		pending:   make(map[int]chan targetMessage),
+		events:    make(map[string][]*listener),
+	}
+}
+
+// receive dispatches a message of the target
+func (s *targetSession) receive(message string) {
+	var m targetMessage
+	if json.Unmarshal([]byte(message), &m) != nil {
+		return
+	}
+
+	if m.ID == 0 {
+		s.mutex.Lock()
+		callbacks := s.events[m.Method]
+		s.mutex.Unlock()
+
+		for _, l := range callbacks {
+			l.cb(m.Params)
+		}
+		return
+	}
+
+	s.mutex.Lock()
+	ch := s.pending[m.ID]
+	delete(s.pending, m.ID)
+	s.mutex.Unlock()
+
+	if ch != nil {
+		ch <- m
+	}
+}
+
+// close fails pending and further requests with the reason
+func (s *targetSession) close(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed != "" {
+		return
+	}
+	s.closed = reason
+
+	for id, ch := range s.pending {
+		ch <- targetMessage{ID: id, Error: &targetError{Message: reason}}
+		delete(s.pending, id)
+	}
+	s.events = make(map[string][]*listener)
+}
+
+func (s *targetSession) addEventListener(method string, cb godet.EventCallback) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	l := &listener{cb: cb}
+	s.events[method] = append(s.events[method], l)
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		var callbacks []*listener
+		for _, c := range s.events[method] {
+			if c != l {
+				callbacks = append(callbacks, c)
+			}
+		}
+		s.events[method] = callbacks
+	}
+}
+
+// SendRequest implements Session.SendRequest; the request fails
+// when the target is detached or the session is closed
+func (s *targetSession) SendRequest(method string, params godet.Params) (map[string]interface{}, error) {
+	ch := make(chan targetMessage, 1)
+
+	s.mutex.Lock()
+	if s.closed != "" {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("%s: %s", method, s.closed)
+	}
+	s.lastID++
+	id := s.lastID
+	s.pending[id] = ch
+	s.mutex.Unlock()
+
+	message, err := json.Marshal(map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.parent.SendRequest("Target.sendMessageToTarget", godet.Params{
+		"sessionId": s.sessionID,
+		"message":   string(message),
+	})
+	if err != nil {
+		s.mutex.Lock()
+		delete(s.pending, id)
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	resp := <-ch
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s: %s", method, resp.Error.Message)
+	}
+	return resp.Result, nil
+}
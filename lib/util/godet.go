@@ -1,6 +1,13 @@
 package util
 
-import "github.com/raff/godet"
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/raff/godet"
+)
 
 // SetDeviceMetricsOverride is a wrapper for `Emulation.setDeviceMetricsOverride` call
 // (see https://chromedevtools.github.io/devtools-protocol/tot/Emulation#method-setDeviceMetricsOverride).
@@ -20,3 +27,294 @@ func SetDeviceMetricsOverride(
 	)
 	return
 }
+
+// SetEmulatedMedia is a wrapper for `Emulation.setEmulatedMedia` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Emulation#method-setEmulatedMedia).
+func SetEmulatedMedia(
+	remote *godet.RemoteDebugger, media string, features []MediaFeature,
+) (err error) {
+	if features == nil {
+		features = []MediaFeature{}
+	}
+	_, err = remote.SendRequest(
+		"Emulation.setEmulatedMedia",
+		godet.Params{
+			"media":    string(media),
+			"features": features,
+		},
+	)
+	return
+}
+
+// SetUserAgentOverride is a wrapper for `Network.setUserAgentOverride` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#method-setUserAgentOverride).
+func SetUserAgentOverride(
+	remote *godet.RemoteDebugger, userAgent string, acceptLanguage string,
+) (err error) {
+	params := godet.Params{
+		"userAgent": string(userAgent),
+	}
+	if acceptLanguage != "" {
+		params["acceptLanguage"] = string(acceptLanguage)
+	}
+	_, err = remote.SendRequest("Network.setUserAgentOverride", params)
+	return
+}
+
+// GetUserAgent returns the default browser user agent string
+// using `Browser.getVersion` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Browser#method-getVersion).
+func GetUserAgent(remote *godet.RemoteDebugger) (userAgent string, err error) {
+	res, err := remote.SendRequest("Browser.getVersion", godet.Params{})
+	if err != nil {
+		return
+	}
+	userAgent, _ = res["userAgent"].(string)
+	return
+}
+
+// SetLocaleOverride is a wrapper for `Emulation.setLocaleOverride` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Emulation#method-setLocaleOverride).
+func SetLocaleOverride(remote *godet.RemoteDebugger, locale string) (err error) {
+	_, err = remote.SendRequest(
+		"Emulation.setLocaleOverride",
+		godet.Params{
+			"locale": string(locale),
+		},
+	)
+	return
+}
+
+// SetTimezoneOverride is a wrapper for `Emulation.setTimezoneOverride` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Emulation#method-setTimezoneOverride).
+func SetTimezoneOverride(remote *godet.RemoteDebugger, timezoneID string) (err error) {
+	_, err = remote.SendRequest(
+		"Emulation.setTimezoneOverride",
+		godet.Params{
+			"timezoneId": string(timezoneID),
+		},
+	)
+	return
+}
+
+// SetGeolocationOverride is a wrapper for `Emulation.setGeolocationOverride` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Emulation#method-setGeolocationOverride).
+func SetGeolocationOverride(
+	remote *godet.RemoteDebugger, latitude float64, longitude float64, accuracy float64,
+) (err error) {
+	_, err = remote.SendRequest(
+		"Emulation.setGeolocationOverride",
+		godet.Params{
+			"latitude":  float64(latitude),
+			"longitude": float64(longitude),
+			"accuracy":  float64(accuracy),
+		},
+	)
+	return
+}
+
+// GrantPermissions is a wrapper for `Browser.grantPermissions` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Browser#method-grantPermissions).
+// Permissions are granted to all origins.
+func GrantPermissions(remote *godet.RemoteDebugger, permissions ...string) (err error) {
+	_, err = remote.SendRequest(
+		"Browser.grantPermissions",
+		godet.Params{
+			"permissions": permissions,
+		},
+	)
+	return
+}
+
+// SetExtraHTTPHeaders is a wrapper for `Network.setExtraHTTPHeaders` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#method-setExtraHTTPHeaders).
+func SetExtraHTTPHeaders(remote *godet.RemoteDebugger, headers map[string]string) (err error) {
+	_, err = remote.SendRequest(
+		"Network.setExtraHTTPHeaders",
+		godet.Params{
+			"headers": headers,
+		},
+	)
+	return
+}
+
+// SetCookies is a wrapper for `Network.setCookies` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#method-setCookies).
+func SetCookies(remote *godet.RemoteDebugger, cookies []Cookie) (err error) {
+	_, err = remote.SendRequest(
+		"Network.setCookies",
+		godet.Params{
+			"cookies": cookies,
+		},
+	)
+	return
+}
+
+// EnableFetch is a wrapper for `Fetch.enable` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Fetch#method-enable).
+// All requests are intercepted.
+func EnableFetch(remote *godet.RemoteDebugger, handleAuthRequests bool) (err error) {
+	_, err = remote.SendRequest(
+		"Fetch.enable",
+		godet.Params{
+			"patterns":           []godet.Params{{"urlPattern": "*"}},
+			"handleAuthRequests": bool(handleAuthRequests),
+		},
+	)
+	return
+}
+
+// GetAllCookies is a wrapper for `Network.getAllCookies` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#method-getAllCookies).
+func GetAllCookies(remote *godet.RemoteDebugger) (cookies []Cookie, err error) {
+	res, err := remote.SendRequest("Network.getAllCookies", godet.Params{})
+	if err != nil {
+		return
+	}
+
+	err = decodeResult(res["cookies"], &cookies)
+	if err != nil {
+		return
+	}
+
+	for i := range cookies {
+		// session cookies are reported with negative expiration time
+		if cookies[i].Expires < 0 {
+			cookies[i].Expires = 0
+		}
+	}
+	return
+}
+
+// GetFrameTree is a wrapper for `Page.getFrameTree` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Page#method-getFrameTree).
+func GetFrameTree(remote *godet.RemoteDebugger) (tree *FrameTree, err error) {
+	res, err := remote.SendRequest("Page.getFrameTree", godet.Params{})
+	if err != nil {
+		return
+	}
+
+	tree = &FrameTree{}
+	err = decodeResult(res["frameTree"], tree)
+	return
+}
+
+// GetDOMStorageItems is a wrapper for `DOMStorage.getDOMStorageItems` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/DOMStorage#method-getDOMStorageItems).
+func GetDOMStorageItems(
+	remote *godet.RemoteDebugger, securityOrigin string, isLocalStorage bool,
+) (items map[string]string, err error) {
+	res, err := remote.SendRequest(
+		"DOMStorage.getDOMStorageItems",
+		godet.Params{
+			"storageId": godet.Params{
+				"securityOrigin": string(securityOrigin),
+				"isLocalStorage": bool(isLocalStorage),
+			},
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	var entries [][]string
+	err = decodeResult(res["entries"], &entries)
+	if err != nil {
+		return
+	}
+
+	items = make(map[string]string)
+	for _, entry := range entries {
+		if len(entry) == 2 {
+			items[entry[0]] = entry[1]
+		}
+	}
+	return
+}
+
+// decodeResult converts a generic DevTools response value
+// into a typed structure
+func decodeResult(value interface{}, v interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// GetResponseBody is a wrapper for `Network.getResponseBody` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Network#method-getResponseBody).
+// Base64-encoded bodies are decoded.
+func GetResponseBody(ctx context.Context, remote *godet.RemoteDebugger, requestID string) (body []byte, err error) {
+	res, err := SendRequest(
+		ctx, remote,
+		"Network.getResponseBody",
+		godet.Params{
+			"requestId": string(requestID),
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	s, ok := res["body"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Failed to fetch the resource (internal error)")
+	}
+
+	if encoded, _ := res["base64Encoded"].(bool); encoded {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// CaptureScreenshot is a wrapper for `Page.captureScreenshot` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Page#method-captureScreenshot)
+// that captures the viewport in PNG format.
+func CaptureScreenshot(ctx context.Context, remote *godet.RemoteDebugger) (data []byte, err error) {
+	res, err := SendRequest(
+		ctx, remote,
+		"Page.captureScreenshot",
+		godet.Params{
+			"format":      "png",
+			"fromSurface": true,
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	s, _ := res["data"].(string)
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// CaptureFullPageScreenshot is a wrapper for `Page.captureScreenshot` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Page#method-captureScreenshot)
+// that captures the area of the given size beyond the viewport in PNG format.
+func CaptureFullPageScreenshot(ctx context.Context, remote *godet.RemoteDebugger, width int, height int) (data []byte, err error) {
+	res, err := SendRequest(
+		ctx, remote,
+		"Page.captureScreenshot",
+		godet.Params{
+			"format":                "png",
+			"captureBeyondViewport": true,
+			"clip": godet.Params{
+				"x":      0,
+				"y":      0,
+				"width":  int(width),
+				"height": int(height),
+				"scale":  1,
+			},
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	s, _ := res["data"].(string)
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,284 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/raff/godet"
+)
+
+// dragSteps is the number of intermediate mouse moves during drag-and-drop
+const dragSteps = 10
+
+// specialKeys maps named keys to their Windows virtual key codes
+var specialKeys = map[string]int{
+	"Backspace":  8,
+	"Tab":        9,
+	"Enter":      13,
+	"Shift":      16,
+	"Control":    17,
+	"Alt":        18,
+	"Escape":     27,
+	"Space":      32,
+	"PageUp":     33,
+	"PageDown":   34,
+	"End":        35,
+	"Home":       36,
+	"ArrowLeft":  37,
+	"ArrowUp":    38,
+	"ArrowRight": 39,
+	"ArrowDown":  40,
+	"Delete":     46,
+}
+
+// DispatchMouseEvent is a wrapper for `Input.dispatchMouseEvent` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Input#method-dispatchMouseEvent).
+func DispatchMouseEvent(
+	ctx context.Context, remote *godet.RemoteDebugger, eventType string, x float64, y float64,
+	button string, clickCount int,
+) (err error) {
+	_, err = SendRequest(
+		ctx, remote,
+		"Input.dispatchMouseEvent",
+		godet.Params{
+			"type":       string(eventType),
+			"x":          float64(x),
+			"y":          float64(y),
+			"button":     string(button),
+			"clickCount": int(clickCount),
+		},
+	)
+	return
+}
+
+// DispatchKeyEvent is a wrapper for `Input.dispatchKeyEvent` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Input#method-dispatchKeyEvent).
+func DispatchKeyEvent(ctx context.Context, remote *godet.RemoteDebugger, params godet.Params) (err error) {
+	_, err = SendRequest(ctx, remote, "Input.dispatchKeyEvent", params)
+	return
+}
+
+// SetFileInputFiles is a wrapper for `DOM.setFileInputFiles` call
+// (see https://chromedevtools.github.io/devtools-protocol/tot/DOM#method-setFileInputFiles).
+// File paths must be accessible to the browser.
+func SetFileInputFiles(ctx context.Context, remote *godet.RemoteDebugger, nodeID int, files []string) (err error) {
+	_, err = SendRequest(
+		ctx, remote,
+		"DOM.setFileInputFiles",
+		godet.Params{
+			"nodeId": int(nodeID),
+			"files":  files,
+		},
+	)
+	return
+}
+
+// QuerySelectorNode returns the DOM node ID of the first element
+// matching the selector using `DOM.getDocument` and `DOM.querySelector` calls
+// (see https://chromedevtools.github.io/devtools-protocol/tot/DOM#method-querySelector).
+func QuerySelectorNode(ctx context.Context, remote *godet.RemoteDebugger, selector string) (nodeID int, err error) {
+	res, err := SendRequest(ctx, remote, "DOM.getDocument", godet.Params{"depth": 0})
+	if err != nil {
+		return
+	}
+
+	root, _ := res["root"].(map[string]interface{})
+	rootID, _ := root["nodeId"].(float64)
+
+	res, err = SendRequest(
+		ctx, remote,
+		"DOM.querySelector",
+		godet.Params{
+			"nodeId":   int(rootID),
+			"selector": string(selector),
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	id, _ := res["nodeId"].(float64)
+	if id == 0 {
+		return 0, fmt.Errorf("Element '%s' not found", selector)
+	}
+	return int(id), nil
+}
+
+// ElementCenter scrolls the first element matching the selector
+// into view and returns the coordinates of its center in the viewport
+func ElementCenter(ctx context.Context, remote *godet.RemoteDebugger, selector string) (x float64, y float64, err error) {
+	res, err := EvaluateFunc(ctx, remote, fmt.Sprintf(`
+		const el = document.querySelector(%s);
+		if (!el) return null;
+		el.scrollIntoView({block: 'center', inline: 'center'});
+		const r = el.getBoundingClientRect();
+		return [r.left + r.width / 2, r.top + r.height / 2];
+	`, JSString(selector)))
+	if err != nil {
+		return
+	}
+
+	point, _ := res.([]interface{})
+	if len(point) != 2 {
+		return 0, 0, fmt.Errorf("Element '%s' not found", selector)
+	}
+
+	x, _ = point[0].(float64)
+	y, _ = point[1].(float64)
+	return
+}
+
+// MouseMove moves the mouse pointer to the given coordinates
+func MouseMove(ctx context.Context, remote *godet.RemoteDebugger, x float64, y float64) error {
+	return DispatchMouseEvent(ctx, remote, "mouseMoved", x, y, "none", 0)
+}
+
+// MouseClick moves the mouse pointer to the given coordinates
+// and clicks the left mouse button
+func MouseClick(ctx context.Context, remote *godet.RemoteDebugger, x float64, y float64) (err error) {
+	err = MouseMove(ctx, remote, x, y)
+	if err != nil {
+		return
+	}
+
+	err = DispatchMouseEvent(ctx, remote, "mousePressed", x, y, "left", 1)
+	if err != nil {
+		return
+	}
+
+	return DispatchMouseEvent(ctx, remote, "mouseReleased", x, y, "left", 1)
+}
+
+// ClickElement clicks at the center of the first element
+// matching the selector
+func ClickElement(ctx context.Context, remote *godet.RemoteDebugger, selector string) error {
+	x, y, err := ElementCenter(ctx, remote, selector)
+	if err != nil {
+		return err
+	}
+	return MouseClick(ctx, remote, x, y)
+}
+
+// DragAndDrop drags the first element matching the source selector
+// and drops it at the center of the first element matching the target
+// selector, moving the mouse pointer in several steps
+func DragAndDrop(ctx context.Context, remote *godet.RemoteDebugger, fromSelector string, toSelector string) (err error) {
+	x1, y1, err := ElementCenter(ctx, remote, fromSelector)
+	if err != nil {
+		return
+	}
+
+	err = MouseMove(ctx, remote, x1, y1)
+	if err != nil {
+		return
+	}
+
+	err = DispatchMouseEvent(ctx, remote, "mousePressed", x1, y1, "left", 1)
+	if err != nil {
+		return
+	}
+
+	x2, y2, err := ElementCenter(ctx, remote, toSelector)
+	if err != nil {
+		return
+	}
+
+	for i := 1; i <= dragSteps; i++ {
+		k := float64(i) / dragSteps
+		_, err = SendRequest(
+			ctx, remote,
+			"Input.dispatchMouseEvent",
+			godet.Params{
+				"type":    "mouseMoved",
+				"x":       x1 + (x2-x1)*k,
+				"y":       y1 + (y2-y1)*k,
+				"button":  "left",
+				"buttons": 1,
+			},
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	return DispatchMouseEvent(ctx, remote, "mouseReleased", x2, y2, "left", 1)
+}
+
+// PressKey dispatches keyDown and keyUp events for a named key
+// (e.g. 'Enter', 'Tab', 'Escape', 'ArrowDown') or a single character
+func PressKey(ctx context.Context, remote *godet.RemoteDebugger, key string) (err error) {
+	down := godet.Params{"type": "keyDown", "key": key}
+	up := godet.Params{"type": "keyUp", "key": key}
+
+	if code, ok := specialKeys[key]; ok {
+		for _, p := range []godet.Params{down, up} {
+			p["code"] = key
+			p["windowsVirtualKeyCode"] = code
+		}
+		switch key {
+		case "Enter":
+			down["text"] = "\r"
+		case "Space":
+			down["key"] = " "
+			up["key"] = " "
+			down["text"] = " "
+		}
+	} else if utf8.RuneCountInString(key) == 1 {
+		down["text"] = key
+	} else {
+		return fmt.Errorf("Unknown key: '%s'", key)
+	}
+
+	err = DispatchKeyEvent(ctx, remote, down)
+	if err != nil {
+		return
+	}
+	return DispatchKeyEvent(ctx, remote, up)
+}
+
+// TypeText types the text into the focused element character
+// by character, waiting for the given delay after each key press
+// until the context is done; newlines are typed as 'Enter' key presses
+func TypeText(ctx context.Context, remote *godet.RemoteDebugger, text string, delay time.Duration) (err error) {
+	for _, r := range text {
+		key := string(r)
+		switch r {
+		case '\n':
+			key = "Enter"
+		case '\t':
+			key = "Tab"
+		}
+
+		err = PressKey(ctx, remote, key)
+		if err != nil {
+			return
+		}
+
+		if delay > 0 {
+			err = Sleep(ctx, delay)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// UploadFiles sets the files of the first file input element matching
+// the selector; file paths must be accessible to the browser
+func UploadFiles(ctx context.Context, remote *godet.RemoteDebugger, selector string, files []string) error {
+	nodeID, err := QuerySelectorNode(ctx, remote, selector)
+	if err != nil {
+		return err
+	}
+	return SetFileInputFiles(ctx, remote, nodeID, files)
+}
+
+// JSString returns a JavaScript string literal for s
+func JSString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,177 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPathMacroLength limits the length of the {PATH} macro value
+const maxPathMacroLength = 100
+
+var (
+	macroRegexp   = regexp.MustCompile(`\{([^{}]*)\}`)
+	varNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	unsafeChars   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// builtinMacros lists macros available in output file name templates
+var builtinMacros = map[string]bool{
+	"TIMESTAMP": true, // date and time in `YYYY-MM-DD-hh-mm-ss` format
+	"DATE":      true, // date in `YYYY-MM-DD` format
+	"UNIX":      true, // Unix time in seconds
+	"HOST":      true, // host name (and port) of the URL
+	"PATH":      true, // sanitized URL path ('index' for the root)
+	"URL_HASH":  true, // first 12 hex digits of SHA-1 hash of the URL
+	"INDEX":     true, // 1-based index of the URL in batch mode (1 otherwise)
+	"COMMAND":   true, // command name
+	"STATUS":    true, // HTTP status of the main document (0 if unknown)
+}
+
+// MacroValues holds values for expanding output file name templates
+type MacroValues struct {
+	Time    time.Time
+	Command string
+	URL     string
+	Index   int
+	Status  int
+}
+
+// OutputTemplate is a validated output file name template
+type OutputTemplate struct {
+	template string
+	vars     map[string]string
+}
+
+// ParseVars parses custom macros in `key=value` format
+func ParseVars(params []string) (vars map[string]string, err error) {
+	vars = make(map[string]string)
+	for _, p := range params {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid --var value: '%s'. Expected format: 'key=value'", p)
+		}
+
+		key, value := parts[0], parts[1]
+		if !varNameRegexp.MatchString(key) {
+			return nil, fmt.Errorf("Invalid --var name: '%s'", key)
+		}
+		if builtinMacros[key] {
+			return nil, fmt.Errorf("--var '%s' conflicts with a built-in macro", key)
+		}
+		if strings.ContainsAny(value, `/\`) || value == "." || value == ".." {
+			return nil, fmt.Errorf("--var '%s' value must not contain path separators or be a relative directory", key)
+		}
+		vars[key] = value
+	}
+	return
+}
+
+// ParseOutputTemplate validates the template: all macros must be
+// either built-in or defined in vars, {STATUS}, which is only known
+// after the page is loaded, can only be used in the file name,
+// and '..' path segments are not allowed
+func ParseOutputTemplate(template string, vars map[string]string) (*OutputTemplate, error) {
+	segments := strings.FieldsFunc(template, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	for _, s := range segments {
+		if s == ".." {
+			return nil, fmt.Errorf("Output file name must not contain '..' path segments")
+		}
+	}
+
+	for _, m := range macroRegexp.FindAllStringSubmatch(template, -1) {
+		name := m[1]
+		if _, ok := vars[name]; !ok && !builtinMacros[name] {
+			return nil, fmt.Errorf("Unknown macro in output file name: {%s}", name)
+		}
+	}
+
+	if strings.Contains(filepath.Dir(template), "{STATUS}") {
+		return nil, fmt.Errorf("{STATUS} macro can only be used in the file name, not in directory names")
+	}
+
+	return &OutputTemplate{template: template, vars: vars}, nil
+}
+
+// Uses returns true if the template contains the macro
+func (t *OutputTemplate) Uses(name string) bool {
+	return strings.Contains(t.template, "{"+name+"}")
+}
+
+// Expand returns the file name with all macros expanded; values
+// derived from the URL are sanitized so that they can't introduce
+// path separators or relative directories
+func (t *OutputTemplate) Expand(v *MacroValues) string {
+	return macroRegexp.ReplaceAllStringFunc(t.template, func(m string) string {
+		name := m[1 : len(m)-1]
+		if value, ok := t.vars[name]; ok {
+			return value
+		}
+
+		switch name {
+		case "TIMESTAMP":
+			return v.Time.Format("2006-01-02-15-04-05")
+		case "DATE":
+			return v.Time.Format("2006-01-02")
+		case "UNIX":
+			return strconv.FormatInt(v.Time.Unix(), 10)
+		case "HOST":
+			return hostMacro(v.URL)
+		case "PATH":
+			return pathMacro(v.URL)
+		case "URL_HASH":
+			sum := sha1.Sum([]byte(v.URL))
+			return hex.EncodeToString(sum[:])[:12]
+		case "INDEX":
+			return strconv.Itoa(v.Index)
+		case "COMMAND":
+			return sanitizeMacro(v.Command)
+		case "STATUS":
+			return strconv.Itoa(v.Status)
+		}
+		return m
+	})
+}
+
+func hostMacro(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return sanitizeMacro(strings.ToLower(strings.Replace(u.Host, ":", "_", -1)))
+}
+
+func pathMacro(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+
+	path := sanitizeMacro(strings.Trim(u.Path, "/"))
+	if path == "" {
+		return "index"
+	}
+	if len(path) > maxPathMacroLength {
+		path = path[:maxPathMacroLength]
+	}
+	return path
+}
+
+// sanitizeMacro replaces characters other than letters, digits, dots,
+// dashes and underscores with underscores, and rejects values
+// consisting of dots only (e.g. '..')
+func sanitizeMacro(s string) string {
+	s = unsafeChars.ReplaceAllString(s, "_")
+	if strings.Trim(s, ".") == "" && s != "" {
+		return strings.Repeat("_", len(s))
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// URLMatcher matches URLs against a mask using one of the match modes:
+// 'exact', 'prefix', 'contains' or 'regexp'
+type URLMatcher struct {
+	mode    string
+	pattern string
+	re      *regexp.Regexp
+}
+
+// NewURLMatcher returns a new URLMatcher for the given match mode and mask
+func NewURLMatcher(mode string, pattern string) (m *URLMatcher, err error) {
+	m = &URLMatcher{mode: mode, pattern: pattern}
+
+	switch mode {
+	case "exact", "prefix", "contains":
+		break
+	case "regexp":
+		m.re, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to compile the regular expression: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf(
+			"Unknown match mode: '%s'. Available modes: 'contains', 'exact', 'prefix' or 'regexp'",
+			mode,
+		)
+	}
+	return
+}
+
+// Match returns true if the URL matches the mask
+func (m *URLMatcher) Match(url string) bool {
+	switch m.mode {
+	case "regexp":
+		return m.re.MatchString(url)
+	case "prefix":
+		return strings.HasPrefix(url, m.pattern)
+	case "contains":
+		return strings.Contains(url, m.pattern)
+	default:
+		return url == m.pattern
+	}
+}
+
+// String returns a human-readable representation of the matcher
+func (m *URLMatcher) String() string {
+	return fmt.Sprintf("%s '%s'", m.mode, m.pattern)
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/raff/godet"
+)
+
+// MediaFeature is a CSS media feature to emulate
+type MediaFeature struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MediaOptions holds CSS media emulation settings
+// shared by page-loading commands
+type MediaOptions struct {
+	media         string
+	featuresParam string
+	features      []MediaFeature
+}
+
+// Init specifies command-line flags to parse
+func (o *MediaOptions) Init() {
+	flag.StringVar(&o.media, "media", "", "CSS media type to emulate ('print' or 'screen')")
+	flag.StringVar(
+		&o.featuresParam,
+		"media-feature",
+		"",
+		"Comma-separated list of CSS media features to emulate, e.g. 'prefers-color-scheme=dark,prefers-reduced-motion=reduce'",
+	)
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *MediaOptions) Validate() {
+	switch o.media {
+	case "", "print", "screen":
+		break
+	default:
+		StopOnUsageError(fmt.Sprintf(
+			"Unknown media type: '%s'. Available types: 'print' or 'screen'\n",
+			o.media,
+		))
+	}
+
+	features, err := ParseMediaFeatures(o.featuresParam)
+	if err != nil {
+		StopOnUsageError(err.Error())
+	}
+	o.features = features
+}
+
+// Enabled returns true if any media emulation was requested
+func (o *MediaOptions) Enabled() bool {
+	return o.media != "" || len(o.features) > 0
+}
+
+// Apply applies media emulation settings; it should be called before navigation
+func (o *MediaOptions) Apply(remote *godet.RemoteDebugger) error {
+	if !o.Enabled() {
+		return nil
+	}
+	err := SetEmulatedMedia(remote, o.media, o.features)
+	if err != nil {
+		// most likely, a media feature is not supported by the browser
+		return WithExitCode(ExitUsage, fmt.Errorf("Failed to emulate --media-feature: %v", err))
+	}
+	return nil
+}
+
+// ParseMediaFeatures parses a comma-separated list of `name=value` pairs
+func ParseMediaFeatures(s string) (features []MediaFeature, err error) {
+	if s == "" {
+		return
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid media feature: '%s'. Expected format: 'name=value'", item)
+		}
+
+		// feature names are checked by the browser when applied
+		features = append(features, MediaFeature{
+			Name:  strings.TrimSpace(parts[0]),
+			Value: strings.TrimSpace(parts[1]),
+		})
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// OutputOptions defines how output files are written
+type OutputOptions struct {
+	noClobber bool
+	append    bool
+	fileMode  string
+	mode      os.FileMode
+}
+
+// OutputFile is written atomically: data goes to a temporary file
+// in the directory of the output file, which replaces (or is appended to)
+// the output file on Commit, and is removed on Discard
+type OutputFile struct {
+	*os.File
+	options *OutputOptions
+}
+
+// Init specifies command-line flags to parse
+func (o *OutputOptions) Init() {
+	flag.BoolVar(&o.noClobber, "no-clobber", false, "Fail instead of overwriting an existing output file")
+	flag.BoolVar(&o.append, "append", false, "Append to the output file instead of overwriting it")
+	flag.StringVar(&o.fileMode, "file-mode", "0644", "Permissions of created output files (octal)")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *OutputOptions) Validate() {
+	err := o.parse()
+	if err != nil {
+		StopOnUsageError(err.Error())
+	}
+}
+
+func (o *OutputOptions) parse() error {
+	if o.noClobber && o.append {
+		return fmt.Errorf("--no-clobber and --append can't be used together")
+	}
+
+	mode, err := strconv.ParseUint(o.fileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("Invalid file mode: '%s'. Expected octal permissions, e.g. '0644'", o.fileMode)
+	}
+	o.mode = os.FileMode(mode)
+	return nil
+}
+
+// Create creates missing parent directories of the output file
+// and a temporary file to write the output to; with `--no-clobber`,
+// it fails early if the output file already exists
+func (o *OutputOptions) Create(filename string) (*OutputFile, error) {
+	if o.noClobber {
+		if _, err := os.Stat(filename); err == nil {
+			return nil, fmt.Errorf("File [%s] already exists", filename)
+		}
+	}
+
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile(dir, ".hc-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &OutputFile{File: file, options: o}, nil
+}
+
+// Commit closes the temporary file and moves its contents to the output
+// file, which may differ from the one passed to Create if its name depends
+// on the result of the command (see {STATUS} macro)
+func (f *OutputFile) Commit(filename string) (err error) {
+	defer os.Remove(f.Name())
+
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	if f.options.append {
+		return appendFile(filename, f.Name(), f.options.mode)
+	}
+
+	err = os.Chmod(f.Name(), f.options.mode)
+	if err != nil {
+		return
+	}
+
+	if f.options.noClobber {
+		// unlike rename, link fails if the file exists
+		err = os.Link(f.Name(), filename)
+		if os.IsExist(err) {
+			return fmt.Errorf("File [%s] already exists", filename)
+		}
+		return
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// Discard closes and removes the temporary file,
+// leaving the output file intact
+func (f *OutputFile) Discard() {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// appendFile appends contents of the src file to the dst file
+func appendFile(dst, src string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND|os.O_CREATE, mode)
+	if err != nil {
+		return
+	}
+
+	_, err = io.Copy(out, in)
+	err2 := out.Close()
+	if err == nil {
+		err = err2
+	}
+	return
+}
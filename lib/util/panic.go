@@ -0,0 +1,48 @@
+package util
+
+import "sync"
+
+// panicHandler is called when the command, an event listener
+// or a goroutine started via Go panics
+var panicHandler = struct {
+	sync.Mutex
+	fn func()
+}{}
+
+// OnPanic sets a function to call when the command, an event listener
+// or a goroutine started via Go panics (e.g. to release external
+// resources) before the panic is propagated; a panic in any goroutine
+// terminates the process, so it can't be recovered by the command itself
+func OnPanic(fn func()) {
+	panicHandler.Lock()
+	defer panicHandler.Unlock()
+
+	panicHandler.fn = fn
+}
+
+// HandlePanic calls the function set via OnPanic if the calling
+// goroutine panics, and propagates the panic; it must be deferred
+func HandlePanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	panicHandler.Lock()
+	fn := panicHandler.fn
+	panicHandler.Unlock()
+
+	if fn != nil {
+		fn()
+	}
+	panic(r)
+}
+
+// Go runs fn in a new goroutine, calling the function set via OnPanic
+// if it panics; all goroutines of the command should be started with it
+func Go(fn func()) {
+	go func() {
+		defer HandlePanic()
+		fn()
+	}()
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/raff/godet"
+)
+
+// PreloadOptions holds scripts to run in every document
+// before any of the page scripts
+type PreloadOptions struct {
+	files     StringList
+	worldName string
+	scripts   []string
+}
+
+// Init specifies command-line flags to parse
+func (o *PreloadOptions) Init() {
+	flag.Var(&o.files, "preload-script", "File with JavaScript code to run in every document before page scripts (can be repeated)")
+	flag.StringVar(
+		&o.worldName,
+		"preload-world",
+		"",
+		"Name of an isolated world to run preload scripts in, hidden from page scripts (main world if empty)",
+	)
+}
+
+// Validate reads the scripts and exits with exit code 2 on error
+func (o *PreloadOptions) Validate() {
+	for _, filename := range o.files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			StopOnUsageError(fmt.Sprintf("Failed to read preload script [%s]: %v\n", filename, err))
+		}
+		o.scripts = append(o.scripts, string(data))
+	}
+}
+
+// Apply registers the scripts; it must be called before navigation
+func (o *PreloadOptions) Apply(remote *godet.RemoteDebugger) error {
+	for _, script := range o.scripts {
+		params := godet.Params{"source": script}
+		if o.worldName != "" {
+			params["worldName"] = o.worldName
+		}
+
+		_, err := remote.SendRequest("Page.addScriptToEvaluateOnNewDocument", params)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
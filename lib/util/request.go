@@ -0,0 +1,232 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/raff/godet"
+
+	"github.com/iafan/hc/lib"
+)
+
+// RequestOptions holds extra HTTP headers, cookies and HTTP authentication
+// credentials shared by page-loading commands.
+// Header and cookie values, as well as credentials, are never logged.
+type RequestOptions struct {
+	headerParams     StringList
+	cookieParams     StringList
+	cookieFileParams StringList
+	storageFiles     StringList
+	basicAuthParam   string
+
+	headers  map[string]string
+	cookies  []Cookie
+	storage  []*OriginStorage
+	username string
+	password string
+}
+
+// Init specifies command-line flags to parse
+func (o *RequestOptions) Init() {
+	flag.Var(&o.headerParams, "header", "Extra HTTP header in 'Name: value' format (can be repeated)")
+	flag.Var(
+		&o.cookieParams,
+		"cookie",
+		"Cookie in 'name=value[;domain=...][;path=...][;secure][;httpOnly]' format (can be repeated)",
+	)
+	flag.Var(&o.cookieFileParams, "cookie-file", "File with cookies in Netscape cookies.txt or JSON format (can be repeated)")
+	flag.Var(
+		&o.storageFiles,
+		"storage-file",
+		"File with cookies and localStorage and sessionStorage items exported by 'hc cookies' (can be repeated)",
+	)
+	flag.StringVar(&o.basicAuthParam, "basic-auth", "", "HTTP authentication credentials in 'user:password' format")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *RequestOptions) Validate() {
+	err := o.parse()
+	if err != nil {
+		StopOnUsageError(err.Error())
+	}
+}
+
+func (o *RequestOptions) parse() (err error) {
+	for _, h := range o.headerParams {
+		parts := strings.SplitN(h, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return fmt.Errorf("Invalid header: expected 'Name: value' format")
+		}
+
+		if o.headers == nil {
+			o.headers = make(map[string]string)
+		}
+
+		// header values may be secrets, e.g. API keys
+		lib.RedactHeader(name)
+
+		value := strings.TrimSpace(parts[1])
+		if prev, ok := o.headers[name]; ok {
+			value = prev + ", " + value
+		}
+		o.headers[name] = value
+	}
+
+	for _, filename := range o.cookieFileParams {
+		cookies, err := ReadCookieFile(filename)
+		if err != nil {
+			return err
+		}
+		o.cookies = append(o.cookies, cookies...)
+	}
+
+	for _, filename := range o.storageFiles {
+		dump, err := ReadStorageFile(filename)
+		if err != nil {
+			return err
+		}
+		o.cookies = append(o.cookies, dump.Cookies...)
+		o.storage = append(o.storage, dump.Storage...)
+	}
+
+	for _, s := range o.cookieParams {
+		cookie, err := ParseCookie(s)
+		if err != nil {
+			return err
+		}
+		o.cookies = append(o.cookies, cookie)
+	}
+
+	if o.basicAuthParam != "" {
+		parts := strings.SplitN(o.basicAuthParam, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Invalid HTTP authentication credentials: expected 'user:password' format")
+		}
+		o.username = parts[0]
+		o.password = parts[1]
+	}
+	return
+}
+
+// Apply sets extra headers and cookies, restores storage items
+// and starts answering HTTP authentication challenges;
+// it should be called before navigation.
+// Cookies without domain or URL are bound to the provided page URL.
+func (o *RequestOptions) Apply(remote *godet.RemoteDebugger, pageURL string, verbose bool) (err error) {
+	if len(o.headers) > 0 {
+		if verbose {
+			for name := range o.headers {
+				log.Printf("Setting extra HTTP header: %s", name)
+			}
+		}
+
+		err = remote.NetworkEvents(true)
+		if err != nil {
+			return
+		}
+
+		err = SetExtraHTTPHeaders(remote, o.headers)
+		if err != nil {
+			return
+		}
+	}
+
+	if len(o.cookies) > 0 {
+		cookies := make([]Cookie, len(o.cookies))
+		for i, cookie := range o.cookies {
+			if cookie.Domain == "" && cookie.URL == "" {
+				cookie.URL = pageURL
+			}
+			cookies[i] = cookie
+
+			if verbose {
+				log.Printf("Setting cookie: %s", cookie.Name)
+			}
+		}
+
+		err = SetCookies(remote, cookies)
+		if err != nil {
+			return
+		}
+	}
+
+	if len(o.storage) > 0 {
+		err = o.restoreStorage(remote, verbose)
+		if err != nil {
+			return
+		}
+	}
+
+	if o.username != "" {
+		err = o.handleAuth(remote, verbose)
+	}
+	return
+}
+
+// restoreStorage sets storage items in each document of the stored
+// origins before page scripts run, as storage of an origin
+// is only accessible once a document of that origin is loaded
+func (o *RequestOptions) restoreStorage(remote *godet.RemoteDebugger, verbose bool) error {
+	if verbose {
+		for _, s := range o.storage {
+			log.Printf("Restoring storage items for %s", s.Origin)
+		}
+	}
+
+	script, err := StorageScript(o.storage)
+	if err != nil {
+		return err
+	}
+
+	_, err = remote.SendRequest("Page.addScriptToEvaluateOnNewDocument", godet.Params{"source": script})
+	return err
+}
+
+func (o *RequestOptions) handleAuth(remote *godet.RemoteDebugger, verbose bool) error {
+	AddEventListener(remote, "Fetch.requestPaused", func(params godet.Params) {
+		_, err := remote.SendRequest(
+			"Fetch.continueRequest",
+			godet.Params{"requestId": params["requestId"]},
+		)
+		if err != nil {
+			log.Printf("Fetch.continueRequest Error: %s", err)
+		}
+	})
+
+	// answer each challenge only once, so that wrong credentials
+	// don't result in an endless authentication loop
+	answered := make(map[interface{}]bool)
+
+	AddEventListener(remote, "Fetch.authRequired", func(params godet.Params) {
+		response := godet.Params{
+			"response": "ProvideCredentials",
+			"username": o.username,
+			"password": o.password,
+		}
+
+		requestID := params["requestId"]
+		if answered[requestID] {
+			log.Printf("HTTP authentication failed")
+			response = godet.Params{"response": "CancelAuth"}
+		} else if verbose {
+			log.Printf("Answering HTTP authentication challenge")
+		}
+		answered[requestID] = true
+
+		_, err := remote.SendRequest(
+			"Fetch.continueWithAuth",
+			godet.Params{
+				"requestId":             requestID,
+				"authChallengeResponse": response,
+			},
+		)
+		if err != nil {
+			log.Printf("Fetch.continueWithAuth Error: %s", err)
+		}
+	})
+
+	return EnableFetch(remote, true)
+}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+)
+
+// settleObserverScript installs a MutationObserver that records
+// the time of the last DOM mutation, and returns the number
+// of milliseconds elapsed since then
+const settleObserverScript = `
+	if (window.__hcLastMutation === undefined) {
+		window.__hcLastMutation = Date.now();
+		new MutationObserver(() => { window.__hcLastMutation = Date.now(); })
+			.observe(document, {subtree: true, childList: true, attributes: true, characterData: true});
+	}
+	return Date.now() - window.__hcLastMutation;
+`
+
+// long-lived connections that never finish loading
+var settleIgnoredTypes = map[string]bool{
+	"WebSocket":   true,
+	"EventSource": true,
+}
+
+// SettleOptions holds settings of the 'settle' wait strategy, which waits
+// until there are no DOM mutations and no in-flight network requests
+// for a given quiet window
+type SettleOptions struct {
+	enabled      bool
+	quiet        time.Duration
+	maxRequest   time.Duration
+	pollInterval time.Duration
+
+	mutex        sync.Mutex
+	requests     map[string]time.Time
+	lastActivity time.Time
+}
+
+// Init specifies command-line flags to parse
+func (o *SettleOptions) Init() {
+	flag.BoolVar(&o.enabled, "settle", false, "Wait for the page to settle (no DOM mutations and no network activity)")
+	flag.DurationVar(&o.quiet, "settle-quiet", 500*time.Millisecond, "Quiet window to consider the page settled")
+	flag.DurationVar(
+		&o.maxRequest,
+		"settle-max-request",
+		5*time.Second,
+		"Ignore requests in flight for longer than this time (e.g. long-polling) when waiting for the page to settle",
+	)
+	flag.DurationVar(&o.pollInterval, "settle-poll-interval", 100*time.Millisecond, "Interval between checks of the page state")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *SettleOptions) Validate() {
+	if o.enabled && (o.quiet <= 0 || o.pollInterval <= 0) {
+		StopOnUsageError("Settle quiet window and poll interval must be positive\n")
+	}
+}
+
+// Clone returns a copy of validated options with a fresh state,
+// so that several pages can be tracked concurrently
+func (o *SettleOptions) Clone() *SettleOptions {
+	return &SettleOptions{
+		enabled:      o.enabled,
+		quiet:        o.quiet,
+		maxRequest:   o.maxRequest,
+		pollInterval: o.pollInterval,
+	}
+}
+
+// Enabled returns true if the 'settle' strategy was requested
+func (o *SettleOptions) Enabled() bool {
+	return o.enabled
+}
+
+// Subscribe starts tracking network requests;
+// it should be called before navigation
+func (o *SettleOptions) Subscribe(remote *godet.RemoteDebugger) error {
+	if !o.enabled {
+		return nil
+	}
+
+	o.requests = make(map[string]time.Time)
+	o.lastActivity = time.Now()
+
+	AddEventListener(remote, "Network.requestWillBeSent", func(params godet.Params) {
+		if t, _ := params["type"].(string); settleIgnoredTypes[t] {
+			return
+		}
+		requestID, _ := params["requestId"].(string)
+
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		// redirects reuse the request ID, so keep the original start time
+		if _, ok := o.requests[requestID]; !ok {
+			o.requests[requestID] = time.Now()
+		}
+		o.lastActivity = time.Now()
+	})
+
+	done := func(params godet.Params) {
+		requestID, _ := params["requestId"].(string)
+
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		if _, ok := o.requests[requestID]; ok {
+			delete(o.requests, requestID)
+			o.lastActivity = time.Now()
+		}
+	}
+	AddEventListener(remote, "Network.loadingFinished", done)
+	AddEventListener(remote, "Network.loadingFailed", done)
+
+	return remote.NetworkEvents(true)
+}
+
+// Settle waits until there were no DOM mutations and no in-flight network
+// requests for the quiet window, or fails with an error when the context
+// deadline is exceeded
+func (o *SettleOptions) Settle(ctx context.Context, remote *godet.RemoteDebugger) error {
+	if !o.enabled {
+		return nil
+	}
+
+	var sinceMutation time.Duration
+	for {
+		inFlight, sinceActivity := o.networkState()
+
+		// once the context is done, the remote is closed
+		// and the evaluation fails, so its error is only reported
+		// if the context is still active
+		res, err := remote.EvaluateWrap(settleObserverScript)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if err == nil {
+			ms, _ := res.(float64)
+			sinceMutation = time.Duration(ms) * time.Millisecond
+
+			if sinceMutation >= o.quiet && inFlight == 0 && sinceActivity >= o.quiet {
+				return nil
+			}
+		}
+
+		if ctx.Err() == context.Canceled {
+			return ContextError(ctx)
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return Timeoutf(
+				"Timed out waiting for the page to settle: %d requests in flight, last DOM mutation %v ago",
+				inFlight, sinceMutation,
+			)
+		}
+
+		Sleep(ctx, o.pollInterval)
+	}
+}
+
+// networkState returns the number of in-flight requests (excluding
+// the ones running longer than the limit) and the time since the last
+// network activity
+func (o *SettleOptions) networkState() (inFlight int, sinceActivity time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, started := range o.requests {
+		if time.Since(started) < o.maxRequest {
+			inFlight++
+		}
+	}
+	return inFlight, time.Since(o.lastActivity)
+}
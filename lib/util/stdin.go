@@ -0,0 +1,44 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// stdinUser is the input STDIN is used for; it can only be read once
+var stdinUser = struct {
+	sync.Mutex
+	name string
+}{}
+
+// ClaimStdin reserves STDIN for the input with the given name
+// (e.g. a flag); it fails if STDIN is already used for another input
+func ClaimStdin(name string) error {
+	stdinUser.Lock()
+	defer stdinUser.Unlock()
+
+	if stdinUser.name == name {
+		return fmt.Errorf("STDIN can only be used once for %s", name)
+	}
+	if stdinUser.name != "" {
+		return fmt.Errorf("STDIN can't be used for %s, as it is already used for %s", name, stdinUser.name)
+	}
+	stdinUser.name = name
+	return nil
+}
+
+// ReadInputFile reads the file given for the input with the given name;
+// '-' means STDIN, which can only be used for a single input
+func ReadInputFile(filename string, name string) ([]byte, error) {
+	if filename != "-" {
+		return ioutil.ReadFile(filename)
+	}
+
+	err := ClaimStdin(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(os.Stdin)
+}
@@ -0,0 +1,212 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/raff/godet"
+)
+
+// Frame describes a page frame
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Page#type-Frame)
+type Frame struct {
+	ID             string `json:"id"`
+	ParentID       string `json:"parentId,omitempty"`
+	Name           string `json:"name,omitempty"`
+	URL            string `json:"url"`
+	SecurityOrigin string `json:"securityOrigin"`
+}
+
+// FrameTree describes a page frame hierarchy
+// (see https://chromedevtools.github.io/devtools-protocol/tot/Page#type-FrameTree)
+type FrameTree struct {
+	Frame       Frame        `json:"frame"`
+	ChildFrames []*FrameTree `json:"childFrames,omitempty"`
+}
+
+// Walk calls fn for each frame in the tree, parents first
+func (t *FrameTree) Walk(fn func(frame *Frame)) {
+	fn(&t.Frame)
+	for _, child := range t.ChildFrames {
+		child.Walk(fn)
+	}
+}
+
+// OriginStorage holds localStorage and sessionStorage items of an origin
+type OriginStorage struct {
+	Origin         string            `json:"origin"`
+	LocalStorage   map[string]string `json:"localStorage"`
+	SessionStorage map[string]string `json:"sessionStorage"`
+}
+
+// StorageDump holds cookies and storage items of the page;
+// its JSON representation is accepted by `--cookie-file`
+// and `--storage-file` flags
+type StorageDump struct {
+	Cookies []Cookie         `json:"cookies"`
+	Storage []*OriginStorage `json:"storage"`
+}
+
+// WriteJSON writes the dump in JSON format
+func (d *StorageDump) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// DumpStorage collects all browser cookies and localStorage and sessionStorage
+// items for the origins of all frames of the current page
+func DumpStorage(remote *godet.RemoteDebugger) (dump *StorageDump, err error) {
+	dump = &StorageDump{}
+
+	dump.Cookies, err = GetAllCookies(remote)
+	if err != nil {
+		return
+	}
+
+	tree, err := GetFrameTree(remote)
+	if err != nil {
+		return
+	}
+
+	_, err = remote.SendRequest("DOMStorage.enable", godet.Params{})
+	if err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var origins []string
+	tree.Walk(func(frame *Frame) {
+		origin := frame.SecurityOrigin
+		if origin == "" || origin == "null" || origin == "://" || seen[origin] {
+			return
+		}
+		seen[origin] = true
+		origins = append(origins, origin)
+	})
+
+	for _, origin := range origins {
+		s := &OriginStorage{Origin: origin}
+
+		s.LocalStorage, err = GetDOMStorageItems(remote, origin, true)
+		if err != nil {
+			return
+		}
+
+		s.SessionStorage, err = GetDOMStorageItems(remote, origin, false)
+		if err != nil {
+			return
+		}
+
+		dump.Storage = append(dump.Storage, s)
+	}
+	return
+}
+
+// ReadStorageFile reads cookies and storage items from a file
+// in JSON format (an output of `hc cookies` command)
+func ReadStorageFile(filename string) (dump *StorageDump, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	dump = &StorageDump{}
+	err = json.Unmarshal(data, dump)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse storage file [%s]: %v", filename, err)
+	}
+	return
+}
+
+// StorageScript returns a script restoring localStorage and sessionStorage
+// items in documents of the matching origins; it is meant to be run
+// before page scripts. Items the page has already set are kept, so that
+// the restored values only act as initial ones.
+func StorageScript(storage []*OriginStorage) (string, error) {
+	origins := make(map[string]*OriginStorage)
+	for _, s := range storage {
+		origins[s.Origin] = s
+	}
+
+	data, err := json.Marshal(origins)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s)(%s)", restoreStorageScript, data), nil
+}
+
+const restoreStorageScript = `function(origins) {
+	var s = origins[location.origin];
+	if (!s) {
+		return;
+	}
+
+	function restore(storage, items) {
+		Object.keys(items || {}).forEach(function(key) {
+			if (storage.getItem(key) === null) {
+				storage.setItem(key, items[key]);
+			}
+		});
+	}
+
+	try {
+		restore(window.localStorage, s.localStorage);
+		restore(window.sessionStorage, s.sessionStorage);
+	} catch (e) {
+		// storage is not available (e.g. in sandboxed frames)
+	}
+}`
+
+// WriteNetscapeCookies writes cookies in Netscape cookies.txt format
+func WriteNetscapeCookies(w io.Writer, cookies []Cookie) (err error) {
+	sorted := make([]Cookie, len(cookies))
+	copy(sorted, cookies)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Domain < sorted[j].Domain
+	})
+
+	_, err = io.WriteString(w, "# Netscape HTTP Cookie File\n\n")
+	if err != nil {
+		return
+	}
+
+	for _, c := range sorted {
+		prefix := ""
+		if c.HTTPOnly {
+			prefix = netscapeHTTPOnlyPrefix
+		}
+
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+
+		_, err = fmt.Fprintf(
+			w, "%s%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			prefix,
+			c.Domain,
+			netscapeBool(strings.HasPrefix(c.Domain, ".")),
+			path,
+			netscapeBool(c.Secure),
+			int64(c.Expires),
+			c.Name,
+			c.Value,
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
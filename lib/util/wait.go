@@ -0,0 +1,279 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raff/godet"
+)
+
+// waitCondition is a single condition to wait for after the page is loaded
+type waitCondition struct {
+	kind  string
+	value string
+
+	// JavaScript expression evaluating to true when the condition is met
+	// (for page-side conditions)
+	script string
+
+	// URL matcher (for 'url' and 'response' conditions)
+	matcher *URLMatcher
+
+	// set when a matching response is received (for 'response' conditions)
+	met bool
+}
+
+func (c *waitCondition) String() string {
+	switch c.kind {
+	case "selector":
+		return fmt.Sprintf("element '%s'", c.value)
+	case "function":
+		return fmt.Sprintf("function '%s'", c.value)
+	case "text":
+		return fmt.Sprintf("text '%s'", c.value)
+	case "url":
+		return fmt.Sprintf("URL %s", c.matcher)
+	default:
+		return fmt.Sprintf("response %s", c.matcher)
+	}
+}
+
+// WaitOptions holds conditions to wait for after the page lifecycle
+// event, shared by page-loading commands
+type WaitOptions struct {
+	selectors    StringList
+	functions    StringList
+	texts        StringList
+	urls         StringList
+	responses    StringList
+	mode         string
+	matchMode    string
+	pollInterval time.Duration
+
+	mutex      sync.Mutex
+	conditions []*waitCondition
+}
+
+// Init specifies command-line flags to parse
+func (o *WaitOptions) Init() {
+	flag.Var(&o.selectors, "wait-for-selector", "Wait for an element matching the CSS selector to appear (can be repeated)")
+	flag.Var(&o.functions, "wait-for-function", "Wait for the JavaScript predicate to become truthy (can be repeated)")
+	flag.Var(&o.texts, "wait-for-text", "Wait for the text to appear on the page (can be repeated)")
+	flag.Var(&o.urls, "wait-for-url", "Wait for the page URL to match the mask (can be repeated)")
+	flag.Var(&o.responses, "wait-for-response", "Wait for a response with the URL matching the mask (can be repeated)")
+	flag.StringVar(&o.mode, "wait-mode", "all", "Wait for 'all' or 'any' of the conditions to be met")
+	flag.StringVar(
+		&o.matchMode,
+		"wait-match",
+		"contains",
+		"Match mode to use for --wait-for-url and --wait-for-response ('contains', 'exact', 'prefix' or 'regexp')",
+	)
+	flag.DurationVar(&o.pollInterval, "wait-poll-interval", 100*time.Millisecond, "Interval between checks of page conditions")
+}
+
+// Validate validates parsed flags and exits with exit code 2 on error
+func (o *WaitOptions) Validate() {
+	err := o.parse()
+	if err != nil {
+		StopOnUsageError(err.Error())
+	}
+}
+
+func (o *WaitOptions) parse() (err error) {
+	switch o.mode {
+	case "all", "any":
+		break
+	default:
+		return fmt.Errorf("Unknown wait mode: '%s'. Available modes: 'all' or 'any'", o.mode)
+	}
+
+	if o.pollInterval <= 0 {
+		return fmt.Errorf("Wait poll interval must be positive")
+	}
+
+	for _, s := range o.selectors {
+		o.add("selector", s, fmt.Sprintf("document.querySelector(%s) !== null", JSString(s)))
+	}
+
+	for _, s := range o.functions {
+		// accept both expressions and functions
+		o.add("function", s, fmt.Sprintf(
+			"(() => { const v = (%s); return typeof v === 'function' ? v() : v; })()",
+			s,
+		))
+	}
+
+	for _, s := range o.texts {
+		o.add("text", s, fmt.Sprintf(
+			"!!document.body && document.body.innerText.indexOf(%s) !== -1",
+			JSString(s),
+		))
+	}
+
+	for _, s := range o.urls {
+		c := o.add("url", s, "")
+		c.matcher, err = NewURLMatcher(o.matchMode, s)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, s := range o.responses {
+		c := o.add("response", s, "")
+		c.matcher, err = NewURLMatcher(o.matchMode, s)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (o *WaitOptions) add(kind string, value string, script string) *waitCondition {
+	c := &waitCondition{kind: kind, value: value, script: script}
+	o.conditions = append(o.conditions, c)
+	return c
+}
+
+// Clone returns a copy of validated options with a fresh state,
+// so that several pages can be waited for concurrently
+func (o *WaitOptions) Clone() *WaitOptions {
+	c := &WaitOptions{
+		responses:    o.responses,
+		mode:         o.mode,
+		pollInterval: o.pollInterval,
+	}
+	for _, cond := range o.conditions {
+		cc := *cond
+		cc.met = false
+		c.conditions = append(c.conditions, &cc)
+	}
+	return c
+}
+
+// Enabled returns true if any wait conditions were requested
+func (o *WaitOptions) Enabled() bool {
+	return len(o.conditions) > 0
+}
+
+// Subscribe starts tracking network responses for 'response'
+// conditions; it should be called before navigation
+func (o *WaitOptions) Subscribe(remote *godet.RemoteDebugger) (err error) {
+	if len(o.responses) == 0 {
+		return
+	}
+
+	AddEventListener(remote, "Network.responseReceived", func(params godet.Params) {
+		resp, _ := params["response"].(map[string]interface{})
+		url, _ := resp["url"].(string)
+
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+
+		for _, c := range o.conditions {
+			if c.kind == "response" && c.matcher.Match(url) {
+				c.met = true
+			}
+		}
+	})
+
+	return remote.NetworkEvents(true)
+}
+
+// Wait waits until all (or any, depending on the mode) of the conditions
+// are met, or fails with an error naming unmet conditions when the context
+// deadline is exceeded
+func (o *WaitOptions) Wait(ctx context.Context, remote *godet.RemoteDebugger) error {
+	if !o.Enabled() {
+		return nil
+	}
+
+	unmet := o.conditions
+	for {
+		// once the context is done, the remote is closed
+		// and the check fails, so its error is only reported
+		// if the context is still active
+		current, err := o.check(remote)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if err == nil {
+			unmet = current
+			if len(unmet) == 0 || (o.mode == "any" && len(unmet) < len(o.conditions)) {
+				return nil
+			}
+		}
+
+		if ctx.Err() == context.Canceled {
+			return ContextError(ctx)
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			names := make([]string, len(unmet))
+			for i, c := range unmet {
+				names[i] = c.String()
+			}
+			return Timeoutf("Timed out waiting for %s of: %s", o.mode, strings.Join(names, ", "))
+		}
+
+		Sleep(ctx, o.pollInterval)
+	}
+}
+
+// check returns the list of unmet conditions; all page-side
+// conditions are checked with a single evaluation
+func (o *WaitOptions) check(remote *godet.RemoteDebugger) (unmet []*waitCondition, err error) {
+	var scripts []string
+	for _, c := range o.conditions {
+		switch c.kind {
+		case "url":
+			scripts = append(scripts, "location.href")
+		case "response":
+			break
+		default:
+			// exceptions mean the page is not ready yet
+			scripts = append(scripts, "(() => { try { return !!("+c.script+"); } catch (e) { return false; } })()")
+		}
+	}
+
+	var values []interface{}
+	if len(scripts) > 0 {
+		var res interface{}
+		res, err = remote.EvaluateWrap("return [" + strings.Join(scripts, ",\n") + "]")
+		if err != nil {
+			return
+		}
+		values, _ = res.([]interface{})
+		if len(values) != len(scripts) {
+			return nil, fmt.Errorf("Failed to check wait conditions (internal error)")
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	i := 0
+	for _, c := range o.conditions {
+		met := c.met
+		switch c.kind {
+		case "url":
+			url, _ := values[i].(string)
+			met = c.matcher.Match(url)
+			i++
+		case "response":
+			break
+		default:
+			met, _ = values[i].(bool)
+			i++
+		}
+
+		if !met {
+			unmet = append(unmet, c)
+		}
+	}
+	return
+}